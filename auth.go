@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	sessionKey
+)
+
+// legacySidWarning is sent with responses to requests that passed their sid
+// the old way
+const legacySidWarning = `299 - "sid in the query string or body is deprecated, send Authorization: Bearer <sid>"`
+
+// authenticate wraps a handler that needs a signed in user. The sid is read
+// from the Authorization header (falling back to the query string or body
+// while session.legacy_sid is on) and checked against the store. Handlers
+// reach the authenticated user with currentUser and currentSession.
+func authenticate(h http.HandlerFunc) http.Handler {
+	return authMiddleware(h, true)
+}
+
+// optionalAuthenticate is authenticate for routes anonymous users can use
+// too. Requests without a sid get the zero User, bad sids are still refused.
+func optionalAuthenticate(h http.HandlerFunc) http.Handler {
+	return authMiddleware(h, false)
+}
+
+func authMiddleware(h http.HandlerFunc, required bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sid, legacy := requestSid(req)
+		if sid == "" && !required {
+			h(w, req)
+			return
+		}
+		if sid == "" {
+			unauthorized(w, "Missing Session ID")
+			return
+		}
+		if legacy {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Add("Warning", legacySidWarning)
+			warnf("%s %s passed its sid outside the Authorization header\n", req.Method, req.URL.Path)
+		}
+
+		s, err := store.FindSession(sid)
+		if err == ErrNotFound {
+			unauthorized(w, "Unknown Session ID")
+			return
+		}
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		now := time.Now()
+		if sessionExpired(s, now) {
+			store.DeleteSession(s.Id)
+			unauthorized(w, "Session expired")
+			return
+		}
+		s = touchSession(s, now)
+
+		user, err := store.FindUser(s.UserId)
+		if err == ErrNotFound {
+			// The account is gone, so is the session
+			store.DeleteSession(s.Id)
+			unauthorized(w, "Couldn't find User matching Session")
+			return
+		}
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), sessionKey, s)
+		ctx = context.WithValue(ctx, userKey, user)
+		h(w, req.WithContext(ctx))
+	})
+}
+
+// requestSid finds the sid a request was made with. legacy is true when it
+// came from the query string or JSON body rather than the Authorization header.
+func requestSid(req *http.Request) (sid string, legacy bool) {
+	if h := req.Header.Get("Authorization"); h != "" {
+		if len(h) > len("Bearer ") && strings.EqualFold(h[:len("Bearer ")], "Bearer ") {
+			return strings.TrimSpace(h[len("Bearer "):]), false
+		}
+		return "", false
+	}
+	if !config.Session.LegacySid {
+		return "", false
+	}
+
+	if sid := req.URL.Query().Get("sid"); sid != "" {
+		return sid, true
+	}
+	if req.Body == nil {
+		return "", false
+	}
+
+	// Peek at the body and put it back for the handler
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+	var p struct {
+		Sid string `json:"sid"`
+	}
+	if json.Unmarshal(body, &p) == nil && p.Sid != "" {
+		return p.Sid, true
+	}
+	return "", false
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="goreson"`)
+	sendError(w, http.StatusUnauthorized, CodeUnauthorized, msg, nil)
+}
+
+// currentUser is the User authenticated by the authenticate middleware, or
+// the zero User for anonymous requests
+func currentUser(req *http.Request) User {
+	user, _ := req.Context().Value(userKey).(User)
+	return user
+}
+
+// currentSession is the UserSession the request was authenticated with
+func currentSession(req *http.Request) UserSession {
+	s, _ := req.Context().Value(sessionKey).(UserSession)
+	return s
+}
+
+// authorizeUser checks that a user id taken from the route belongs to the
+// session user, so users can only act on their own resources
+func authorizeUser(id string, w http.ResponseWriter, req *http.Request) bool {
+	user := currentUser(req)
+	if id == user.Id {
+		return true
+	}
+
+	other := User{}
+	if ok := findUser(id, &other, w, req); !ok {
+		return false
+	}
+	log.Printf("Session  User.Id: %+v\n", user.Id)
+	log.Printf("Resource User.Id: %+v\n", other.Id)
+	forbidden(w, "Users can only act on their own resources")
+	return false
+}
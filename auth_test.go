@@ -0,0 +1,55 @@
+package main
+
+import (
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticateBearer(t *testing.T) {
+  store = NewMemoryStore()
+  _, sid := newTestUser(t, "Arya")
+
+  resp := doRequest("GET", "/api/v1/messages", sid, "")
+  assert.Equal(t, 200, resp.Code)
+  assert.Empty(t, resp.Header().Get("Deprecation"))
+
+  resp = doRequest("GET", "/api/v1/messages", "", "")
+  assert.Equal(t, 401, resp.Code)
+  assert.Contains(t, resp.Header().Get("WWW-Authenticate"), "Bearer")
+
+  resp = doRequest("GET", "/api/v1/messages", "not-a-session", "")
+  assert.Equal(t, 401, resp.Code)
+}
+
+func TestAuthenticateLegacySid(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Sansa")
+
+  resp := doRequest("GET", "/api/v1/messages?sid="+sid, "", "")
+  assert.Equal(t, 200, resp.Code)
+  assert.Equal(t, "true", resp.Header().Get("Deprecation"))
+
+  // the handler still gets to read the body the sid came in
+  resp = doRequest("POST", "/api/v1/users/"+user.Id+"/events", "", `{"event": {"title": "Wedding"}, "sid": "`+sid+`"}`)
+  assert.Equal(t, 200, resp.Code)
+  assert.Contains(t, resp.Body.String(), "Wedding")
+  assert.Equal(t, "true", resp.Header().Get("Deprecation"))
+
+  config.Session.LegacySid = false
+  defer func() { config.Session.LegacySid = true }()
+  resp = doRequest("GET", "/api/v1/messages?sid="+sid, "", "")
+  assert.Equal(t, 401, resp.Code)
+}
+
+func TestAuthorizeUser(t *testing.T) {
+  store = NewMemoryStore()
+  _, sid := newTestUser(t, "Gendry")
+  other, _ := newTestUser(t, "Hot Pie")
+
+  resp := doRequest("GET", "/api/v1/users/"+other.Id+"/events", sid, "")
+  assert.Equal(t, 403, resp.Code)
+
+  resp = doRequest("GET", "/api/v1/users/missing/events", sid, "")
+  assert.Equal(t, 404, resp.Code)
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	r "github.com/dancannon/gorethink"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds every runtime setting. Values are resolved in increasing order
+// of precedence: defaults, config file, environment variables, flags.
+type Config struct {
+	Addr       string           `json:"addr"        yaml:"addr"`
+	Store      string           `json:"store"       yaml:"store"`
+	LogLevel   string           `json:"log_level"   yaml:"log_level"`
+	Debug      bool             `json:"debug"       yaml:"debug"`
+	DB         DBConfig         `json:"db"          yaml:"db"`
+	Server     ServerConfig     `json:"server"      yaml:"server"`
+	Pagination PaginationConfig `json:"pagination"  yaml:"pagination"`
+	Auth       AuthConfig       `json:"auth"        yaml:"auth"`
+	Session    SessionConfig    `json:"session"     yaml:"session"`
+	Geo        GeoConfig        `json:"geo"         yaml:"geo"`
+	// Notifications older than this are pruned, 0 keeps them forever
+	NotificationRetention Duration `json:"notification_retention" yaml:"notification_retention"`
+	// How long invite links work when the host doesn't say, 0 for forever
+	InviteLinkTTL Duration `json:"invite_link_ttl" yaml:"invite_link_ttl"`
+	// Also accept dates in TimeFormat besides RFC 3339, for older clients
+	LegacyTimeFormat bool `json:"legacy_time_format" yaml:"legacy_time_format"`
+}
+
+type DBConfig struct {
+	Address      string    `json:"address"        yaml:"address"`
+	Database     string    `json:"database"       yaml:"database"`
+	AuthKey      string    `json:"auth_key"       yaml:"auth_key"`
+	TLS          TLSConfig `json:"tls"            yaml:"tls"`
+	Timeout      Duration  `json:"timeout"        yaml:"timeout"`
+	ReadTimeout  Duration  `json:"read_timeout"   yaml:"read_timeout"`
+	WriteTimeout Duration  `json:"write_timeout"  yaml:"write_timeout"`
+}
+
+type TLSConfig struct {
+	Enabled            bool   `json:"enabled"               yaml:"enabled"`
+	CAFile             string `json:"ca_file"               yaml:"ca_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"  yaml:"insecure_skip_verify"`
+}
+
+type ServerConfig struct {
+	ReadTimeout  Duration `json:"read_timeout"   yaml:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"  yaml:"write_timeout"`
+}
+
+type PaginationConfig struct {
+	DefaultPer int `json:"default_per"  yaml:"default_per"`
+	MaxPer     int `json:"max_per"      yaml:"max_per"`
+}
+
+type AuthConfig struct {
+	FacebookAppId     string `json:"facebook_app_id"      yaml:"facebook_app_id"`
+	FacebookAppSecret string `json:"facebook_app_secret"  yaml:"facebook_app_secret"`
+	FacebookGraphURL  string `json:"facebook_graph_url"   yaml:"facebook_graph_url"`
+	FakeFacebook      bool   `json:"fake_facebook"        yaml:"fake_facebook"`
+}
+
+// SessionConfig limits how long a sid stays valid. Zero disables a limit.
+// LegacySid still accepts a sid in the query string or body, with a warning,
+// for clients that don't send an Authorization header yet.
+type SessionConfig struct {
+	TTL         Duration `json:"ttl"           yaml:"ttl"`
+	IdleTimeout Duration `json:"idle_timeout"  yaml:"idle_timeout"`
+	LegacySid   bool     `json:"legacy_sid"    yaml:"legacy_sid"`
+}
+
+// GeoConfig bounds the radius of location searches
+type GeoConfig struct {
+	DefaultRadiusKm float64 `json:"default_radius_km"  yaml:"default_radius_km"`
+	MaxRadiusKm     float64 `json:"max_radius_km"      yaml:"max_radius_km"`
+}
+
+// Duration is a time.Duration read and written as a string like "5s"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d *Duration) set(s string) (err error) {
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// config is the active configuration; main replaces it with the loaded one
+var config = DefaultConfig()
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:     "0.0.0.0:3000",
+		Store:    "rethink",
+		LogLevel: "info",
+		DB: DBConfig{
+			Address:      "db:28015",
+			Database:     "gadder",
+			Timeout:      Duration{10 * time.Second},
+			ReadTimeout:  Duration{30 * time.Second},
+			WriteTimeout: Duration{30 * time.Second},
+		},
+		Server: ServerConfig{
+			ReadTimeout:  Duration{30 * time.Second},
+			WriteTimeout: Duration{30 * time.Second},
+		},
+		Pagination: PaginationConfig{
+			DefaultPer: 20,
+			MaxPer:     100,
+		},
+		Auth: AuthConfig{
+			FacebookGraphURL: "https://graph.facebook.com/v2.5",
+		},
+		Session: SessionConfig{
+			TTL:         Duration{30 * 24 * time.Hour},
+			IdleTimeout: Duration{7 * 24 * time.Hour},
+			LegacySid:   true,
+		},
+		Geo: GeoConfig{
+			DefaultRadiusKm: 25,
+			MaxRadiusKm:     500,
+		},
+		NotificationRetention: Duration{7 * 24 * time.Hour},
+		InviteLinkTTL:         Duration{7 * 24 * time.Hour},
+		LegacyTimeFormat:      true,
+	}
+}
+
+// setting ties one Config field to its flag and environment variable
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	ptr   interface{} // *string, *int, *float64, *bool or *Duration
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"addr", "GORESON_ADDR", "address to listen on", &c.Addr},
+		{"store", "GORESON_STORE", "storage backend: rethink or memory", &c.Store},
+		{"log-level", "GORESON_LOG_LEVEL", "debug, info, warn or error", &c.LogLevel},
+		{"debug", "GORESON_DEBUG", "expose debug endpoints such as /config", &c.Debug},
+		{"db-address", "GORESON_DB_ADDRESS", "RethinkDB host:port", &c.DB.Address},
+		{"db-database", "GORESON_DB_DATABASE", "RethinkDB database name", &c.DB.Database},
+		{"db-auth-key", "GORESON_DB_AUTH_KEY", "RethinkDB auth key", &c.DB.AuthKey},
+		{"db-tls", "GORESON_DB_TLS", "connect to RethinkDB over TLS", &c.DB.TLS.Enabled},
+		{"db-tls-ca-file", "GORESON_DB_TLS_CA_FILE", "PEM file of CAs to trust for RethinkDB TLS", &c.DB.TLS.CAFile},
+		{"db-tls-insecure", "GORESON_DB_TLS_INSECURE", "skip RethinkDB TLS certificate verification", &c.DB.TLS.InsecureSkipVerify},
+		{"db-timeout", "GORESON_DB_TIMEOUT", "RethinkDB connect timeout", &c.DB.Timeout},
+		{"db-read-timeout", "GORESON_DB_READ_TIMEOUT", "RethinkDB read timeout", &c.DB.ReadTimeout},
+		{"db-write-timeout", "GORESON_DB_WRITE_TIMEOUT", "RethinkDB write timeout", &c.DB.WriteTimeout},
+		{"read-timeout", "GORESON_READ_TIMEOUT", "HTTP server read timeout", &c.Server.ReadTimeout},
+		{"write-timeout", "GORESON_WRITE_TIMEOUT", "HTTP server write timeout", &c.Server.WriteTimeout},
+		{"per-page", "GORESON_PER_PAGE", "default page size for index routes", &c.Pagination.DefaultPer},
+		{"max-per-page", "GORESON_MAX_PER_PAGE", "largest page size a client may request", &c.Pagination.MaxPer},
+		{"geo-default-radius-km", "GORESON_GEO_DEFAULT_RADIUS_KM", "search radius when a location search doesn't give one", &c.Geo.DefaultRadiusKm},
+		{"geo-max-radius-km", "GORESON_GEO_MAX_RADIUS_KM", "largest search radius a client may request", &c.Geo.MaxRadiusKm},
+		{"notification-retention", "GORESON_NOTIFICATION_RETENTION", "how long notifications are kept for resuming streams (0 for forever)", &c.NotificationRetention},
+		{"invite-link-ttl", "GORESON_INVITE_LINK_TTL", "how long invite links work by default (0 for forever)", &c.InviteLinkTTL},
+		{"legacy-time-format", "GORESON_LEGACY_TIME_FORMAT", "also accept dates like \"Mon Jan 2 2006 15:04:05 MST-07:00\" (deprecated)", &c.LegacyTimeFormat},
+		{"facebook-app-id", "GORESON_FACEBOOK_APP_ID", "Facebook app id used to verify access tokens", &c.Auth.FacebookAppId},
+		{"facebook-app-secret", "GORESON_FACEBOOK_APP_SECRET", "Facebook app secret", &c.Auth.FacebookAppSecret},
+		{"facebook-graph-url", "GORESON_FACEBOOK_GRAPH_URL", "Facebook Graph API base url", &c.Auth.FacebookGraphURL},
+		{"session-ttl", "GORESON_SESSION_TTL", "how long a session lasts from sign in (0 for forever)", &c.Session.TTL},
+		{"session-idle-timeout", "GORESON_SESSION_IDLE_TIMEOUT", "how long an unused session lasts (0 for forever)", &c.Session.IdleTimeout},
+		{"session-legacy-sid", "GORESON_SESSION_LEGACY_SID", "also accept a sid in the query string or body (deprecated)", &c.Session.LegacySid},
+		{"fake-facebook", "GORESON_FAKE_FACEBOOK", "accept fake:<facebook_id> tokens (local development only)", &c.Auth.FakeFacebook},
+	}
+}
+
+func (s setting) set(value string) error {
+	var err error
+	switch p := s.ptr.(type) {
+	case *string:
+		*p = value
+	case *int:
+		*p, err = strconv.Atoi(value)
+	case *float64:
+		*p, err = strconv.ParseFloat(value, 64)
+	case *bool:
+		*p, err = strconv.ParseBool(value)
+	case *Duration:
+		err = p.set(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s: %v", value, s.flag, err)
+	}
+	return nil
+}
+
+// rawFlag holds a flag's value until the file and environment have been applied
+type rawFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *rawFlag) String() string     { return f.value }
+func (f *rawFlag) Set(v string) error { f.value = v; return nil }
+func (f *rawFlag) IsBoolFlag() bool   { return f.isBool }
+
+// LoadConfig resolves the configuration from a config file (-config or
+// GORESON_CONFIG), the environment and command line args. It returns the
+// validated config and the remaining non-flag args.
+func LoadConfig(args []string, getenv func(string) string) (*Config, []string, error) {
+	c := DefaultConfig()
+	settings := c.settings()
+
+	fs := flag.NewFlagSet("goreson", flag.ContinueOnError)
+	path := fs.String("config", getenv("GORESON_CONFIG"), "path to a JSON or YAML config file (env GORESON_CONFIG)")
+	flagValues := make(map[string]*rawFlag, len(settings))
+	for _, s := range settings {
+		_, isBool := s.ptr.(*bool)
+		flagValues[s.flag] = &rawFlag{isBool: isBool}
+		fs.Var(flagValues[s.flag], s.flag, s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *path != "" {
+		if err := c.loadFile(*path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(v); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				flagErr = s.set(flagValues[s.flag].value)
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, fs.Args(), nil
+}
+
+func (c *Config) loadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, c)
+	default:
+		err = json.Unmarshal(b, c)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %v", path, err)
+	}
+	return nil
+}
+
+var databaseName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Validate reports every problem with the config at once
+func (c *Config) Validate() error {
+	problems := []string{}
+	add := func(format string, v ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, v...))
+	}
+
+	if c.Addr == "" {
+		add("addr must not be empty")
+	}
+	if c.Store != "rethink" && c.Store != "memory" {
+		add("store must be rethink or memory, got %q", c.Store)
+	}
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		add("log_level must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	if c.Store == "rethink" {
+		if c.DB.Address == "" {
+			add("db.address must not be empty")
+		}
+		if !databaseName.MatchString(c.DB.Database) {
+			add("db.database must only contain letters, numbers and underscores, got %q", c.DB.Database)
+		}
+		if c.DB.TLS.CAFile != "" && !c.DB.TLS.Enabled {
+			add("db.tls.ca_file is set but db.tls.enabled is false")
+		}
+	}
+	for name, d := range map[string]Duration{
+		"db.timeout":             c.DB.Timeout,
+		"db.read_timeout":        c.DB.ReadTimeout,
+		"db.write_timeout":       c.DB.WriteTimeout,
+		"server.read_timeout":    c.Server.ReadTimeout,
+		"server.write_timeout":   c.Server.WriteTimeout,
+		"session.ttl":            c.Session.TTL,
+		"session.idle_timeout":   c.Session.IdleTimeout,
+		"notification_retention": c.NotificationRetention,
+		"invite_link_ttl":        c.InviteLinkTTL,
+	} {
+		if d.Duration < 0 {
+			add("%s must not be negative", name)
+		}
+	}
+	if (c.Auth.FacebookAppId == "") != (c.Auth.FacebookAppSecret == "") {
+		add("auth.facebook_app_id and auth.facebook_app_secret must be set together")
+	}
+	if c.Pagination.MaxPer < 1 {
+		add("pagination.max_per must be at least 1")
+	}
+	if c.Pagination.DefaultPer < 1 || c.Pagination.DefaultPer > c.Pagination.MaxPer {
+		add("pagination.default_per must be between 1 and pagination.max_per (%d)", c.Pagination.MaxPer)
+	}
+	if c.Geo.MaxRadiusKm <= 0 {
+		add("geo.max_radius_km must be positive")
+	}
+	if c.Geo.DefaultRadiusKm <= 0 || c.Geo.DefaultRadiusKm > c.Geo.MaxRadiusKm {
+		add("geo.default_radius_km must be positive and at most geo.max_radius_km (%g)", c.Geo.MaxRadiusKm)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// ConnectOpts builds the RethinkDB connection options
+func (c *Config) ConnectOpts() (r.ConnectOpts, error) {
+	opts := r.ConnectOpts{
+		Address:      c.DB.Address,
+		Database:     c.DB.Database,
+		AuthKey:      c.DB.AuthKey,
+		Timeout:      c.DB.Timeout.Duration,
+		ReadTimeout:  c.DB.ReadTimeout.Duration,
+		WriteTimeout: c.DB.WriteTimeout.Duration,
+	}
+
+	if c.DB.TLS.Enabled {
+		tlsConfig := &tls.Config{InsecureSkipVerify: c.DB.TLS.InsecureSkipVerify}
+		if c.DB.TLS.CAFile != "" {
+			pem, err := ioutil.ReadFile(c.DB.TLS.CAFile)
+			if err != nil {
+				return opts, fmt.Errorf("reading db.tls.ca_file: %v", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return opts, fmt.Errorf("no certificates found in %s", c.DB.TLS.CAFile)
+			}
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	return opts, nil
+}
+
+// Redacted returns a copy of the config that is safe to show
+func (c *Config) Redacted() Config {
+	redacted := *c
+	if redacted.DB.AuthKey != "" {
+		redacted.DB.AuthKey = "[redacted]"
+	}
+	if redacted.Auth.FacebookAppSecret != "" {
+		redacted.Auth.FacebookAppSecret = "[redacted]"
+	}
+	return redacted
+}
+
+// ConfigHandler shows the active configuration with secrets removed. It is
+// only routed when the server runs with -debug.
+// Example:
+//
+//	Request:
+//	  curl -X GET localhost:3000/config
+//	Response:
+//	  {
+//	      "config": {
+//	          "addr": "0.0.0.0:3000",
+//	          "store": "rethink",
+//	          "log_level": "info",
+//	          "debug": true,
+//	          "db": {
+//	              "address": "db:28015",
+//	              "database": "gadder",
+//	              "auth_key": "[redacted]",
+//	              ...
+//	          },
+//	          ...
+//	      }
+//	  }
+func ConfigHandler(w http.ResponseWriter, req *http.Request) {
+	sendJson(map[string]interface{}{"config": config.Redacted()}, w)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func envFrom(vars map[string]string) func(string) string {
+  return func(key string) string { return vars[key] }
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+  cfg, args, err := LoadConfig([]string{"migrate", "status"}, envFrom(nil))
+  assert.Nil(t, err)
+  assert.Equal(t, []string{"migrate", "status"}, args)
+  assert.Equal(t, "db:28015", cfg.DB.Address)
+  assert.Equal(t, "gadder", cfg.DB.Database)
+  assert.Equal(t, 20, cfg.Pagination.DefaultPer)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+  dir, err := ioutil.TempDir("", "goreson")
+  assert.Nil(t, err)
+  defer os.RemoveAll(dir)
+
+  path := filepath.Join(dir, "goreson.yml")
+  ioutil.WriteFile(path, []byte("db:\n  address: file:28015\n  database: from_file\n  timeout: 3s\npagination:\n  default_per: 5\n"), 0600)
+
+  env := envFrom(map[string]string{
+    "GORESON_CONFIG":      path,
+    "GORESON_DB_DATABASE": "from_env",
+    "GORESON_PER_PAGE":    "7",
+  })
+  cfg, _, err := LoadConfig([]string{"-per-page", "9", "-debug"}, env)
+  assert.Nil(t, err)
+  assert.Equal(t, "file:28015", cfg.DB.Address)   // file beats default
+  assert.Equal(t, "from_env", cfg.DB.Database)    // env beats file
+  assert.Equal(t, 9, cfg.Pagination.DefaultPer)   // flag beats env
+  assert.Equal(t, 3*time.Second, cfg.DB.Timeout.Duration)
+  assert.True(t, cfg.Debug)
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+  _, _, err := LoadConfig([]string{"-store", "mongo", "-per-page", "500", "-log-level", "loud"}, envFrom(nil))
+  assert.NotNil(t, err)
+  assert.Contains(t, err.Error(), "store must be rethink or memory")
+  assert.Contains(t, err.Error(), "pagination.default_per")
+  assert.Contains(t, err.Error(), "log_level")
+
+  _, _, err = LoadConfig([]string{"-db-timeout", "soon"}, envFrom(nil))
+  assert.NotNil(t, err)
+  assert.Contains(t, err.Error(), "db-timeout")
+}
+
+func TestConfigRedacted(t *testing.T) {
+  cfg := DefaultConfig()
+  cfg.DB.AuthKey = "hunter2"
+  b, err := json.Marshal(cfg.Redacted())
+  assert.Nil(t, err)
+  assert.NotContains(t, string(b), "hunter2")
+  assert.Contains(t, string(b), `"timeout":"10s"`)
+  assert.Equal(t, "hunter2", cfg.DB.AuthKey)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+  "encoding/json"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestSessionsPerDevice(t *testing.T) {
+  store = NewMemoryStore()
+  user, _ := newTestUser(t, "Bran")
+
+  phone, err := startSession(user.Id, Device{Id: "phone"})
+  assert.Nil(t, err)
+  _, err = startSession(user.Id, Device{Id: "tablet"})
+  assert.Nil(t, err)
+  // signing in again on the phone replaces its session
+  phone2, err := startSession(user.Id, Device{Id: "phone"})
+  assert.Nil(t, err)
+
+  _, err = store.FindSession(phone.Id)
+  assert.Equal(t, ErrNotFound, err)
+
+  resp := doRequest("GET", "/api/v1/sessions", phone2.Id, "")
+  assert.Equal(t, 200, resp.Code)
+  res := struct {
+    Sessions []UserSession `json:"sessions"`
+  }{}
+  json.Unmarshal(resp.Body.Bytes(), &res)
+  assert.Equal(t, 3, len(res.Sessions)) // newTestUser's, tablet and phone2
+  for _, s := range res.Sessions {
+    assert.Equal(t, s.Id == phone2.Id, s.Current)
+  }
+}
+
+func TestSessionRefreshAndLogout(t *testing.T) {
+  store = NewMemoryStore()
+  _, sid := newTestUser(t, "Hodor")
+
+  resp := doRequest("POST", "/api/v1/sessions/"+sid+"/refresh", sid, "")
+  assert.Equal(t, 200, resp.Code)
+  res := struct {
+    Sid string `json:"sid"`
+  }{}
+  json.Unmarshal(resp.Body.Bytes(), &res)
+  assert.NotEqual(t, sid, res.Sid)
+
+  // the old sid is gone
+  resp = doRequest("GET", "/api/v1/sessions", sid, "")
+  assert.Equal(t, 401, resp.Code)
+
+  resp = doRequest("DELETE", "/api/v1/sessions/"+res.Sid, res.Sid, "")
+  assert.Equal(t, 200, resp.Code)
+  resp = doRequest("GET", "/api/v1/sessions", res.Sid, "")
+  assert.Equal(t, 401, resp.Code)
+}
+
+func TestSessionCannotRevokeOtherUsers(t *testing.T) {
+  store = NewMemoryStore()
+  _, sid := newTestUser(t, "Jon")
+  _, other := newTestUser(t, "Ygritte")
+
+  resp := doRequest("DELETE", "/api/v1/sessions/"+other, sid, "")
+  assert.Equal(t, 404, resp.Code)
+  _, err := store.FindSession(other)
+  assert.Nil(t, err)
+}
+
+func TestSessionExpiry(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Rickon")
+  now := time.Now()
+
+  s, _ := store.FindSession(sid)
+  assert.False(t, sessionExpired(s, now))
+  assert.True(t, sessionExpired(s, now.Add(config.Session.TTL.Duration+time.Minute)))
+
+  // idle sessions expire before their TTL
+  s.LastSeenAt = now.Add(-config.Session.IdleTimeout.Duration - time.Minute)
+  store.UpdateSession(s)
+  resp := doRequest("GET", "/api/v1/sessions", sid, "")
+  assert.Equal(t, 401, resp.Code)
+  _, err := store.FindSession(sid)
+  assert.Equal(t, ErrNotFound, err)
+
+  // sessions from before expiry existed count their TTL from created_at
+  legacy, _ := store.CreateSession(UserSession{UserId: user.Id, CreatedAt: now.Add(-365 * 24 * time.Hour), LastSeenAt: now})
+  assert.True(t, sessionExpired(legacy, now))
+}
+
+func TestDeleteUserRevokesSessions(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Robb")
+  startSession(user.Id, Device{Id: "phone"})
+
+  resp := doRequest("DELETE", "/api/v1/users/"+user.Id, sid, "")
+  assert.Equal(t, 200, resp.Code)
+  sessions, _ := store.ListUserSessions(user.Id)
+  assert.Equal(t, 0, len(sessions))
+}
@@ -1,8 +1,7 @@
 package main
 
 import (
-  r "github.com/dancannon/gorethink"
-  "log"
+  "bytes"
   "time"
   "encoding/json"
 
@@ -15,6 +14,7 @@ import (
 
 type SuiteTester struct {
 	suite.Suite
+	users []User
 }
 
 // Start Suite
@@ -22,17 +22,9 @@ func TestUsersControllerTestSuite(t *testing.T) {
   suite.Run(t, new(SuiteTester))
 }
 
-func (suite *SuiteTester) SetupSuite() {
-  session = InitTestDB()
-  _, err := r.Db("gadder_test").TableCreate("users").RunWrite(session)
-  if err != nil {
-    log.Println(err)
-  }
-}
-
 // Wipe tables tested in suite and reload fixtures before each test
 func (suite *SuiteTester) SetupTest() {
-  r.Table("users").Delete().RunWrite(session)
+  store = NewMemoryStore()
   user_fixtures := make([]User, 4)
   user_fixtures[0] = User{
     FirstName:  "Tyrion",
@@ -71,7 +63,10 @@ func (suite *SuiteTester) SetupTest() {
     UpdatedAt:  time.Date(2014, time.October, 8, 18, 30, 10, 0, time.UTC),
   }
 
-  r.Table("users").Insert(user_fixtures).RunWrite(session)
+  suite.users = make([]User, len(user_fixtures))
+  for i, u := range user_fixtures {
+    suite.users[i], _ = store.CreateUser(u)
+  }
 }
 
 func (suite *SuiteTester) TestUserIndexHandler() {
@@ -93,15 +88,54 @@ func (suite *SuiteTester) TestUserIndexHandler() {
 }
 
 func (suite *SuiteTester) TestUserCreateHandler() {
-  // test various user models
-  // test response code 200
-  
-  // user := User{ Name: "Brian Jones", Bio: "I got nothing" }
-  // body, err = json.Marshal(user)
-  // if err != nil {
-  //   log.Println("Unable to marshal user")
-  // }
-  // req, err := http.NewRequest("POST", "/api/v1/users", bytes.NewReader(body))
+  fake := NewFakeIdentityProvider("facebook")
+  fake.Tokens["brienne-token"] = Identity{Subject: "a9c0f1d2", Email: "brienne@tarth.com"}
+  RegisterIdentityProvider(fake)
+
+  body := []byte(`{"user": {"first_name": "Brienne", "last_name": "Tarth"}, "access_token": "brienne-token"}`)
+  resp := httptest.NewRecorder()
+  req, err := http.NewRequest("POST", "/api/v1/users", bytes.NewReader(body))
+  assert.Nil(suite.T(), err)
+  CreateUserHandler(resp, req)
+  assert.Equal(suite.T(), 200, resp.Code)
+
+  res := struct {
+    User User   `json:"user"`
+    Sid  string `json:"sid"`
+  }{}
+  err = json.Unmarshal(resp.Body.Bytes(), &res)
+  assert.Nil(suite.T(), err)
+  assert.Equal(suite.T(), "Brienne", res.User.FirstName)
+  assert.Equal(suite.T(), "brienne@tarth.com", res.User.Email)
+  assert.NotEmpty(suite.T(), res.User.Id)
+  assert.NotEmpty(suite.T(), res.Sid)
+
+  // signing in with the same identity again returns the existing user
+  resp = httptest.NewRecorder()
+  req, _ = http.NewRequest("POST", "/api/v1/users", bytes.NewReader(body))
+  CreateUserHandler(resp, req)
+  again := struct {
+    User User `json:"user"`
+  }{}
+  json.Unmarshal(resp.Body.Bytes(), &again)
+  assert.Equal(suite.T(), res.User.Id, again.User.Id)
+
+  // a facebook_id alone is no longer enough to sign in
+  resp = httptest.NewRecorder()
+  req, _ = http.NewRequest("POST", "/api/v1/users", bytes.NewReader([]byte(`{"facebook_id": "a9c0f1d2"}`)))
+  CreateUserHandler(resp, req)
+  assert.Equal(suite.T(), 422, resp.Code)
+
+  resp = httptest.NewRecorder()
+  req, _ = http.NewRequest("POST", "/api/v1/users", bytes.NewReader([]byte(`{"access_token": "stolen"}`)))
+  CreateUserHandler(resp, req)
+  assert.Equal(suite.T(), 401, resp.Code)
+
+  // a misspelled provider is the client's mistake, not an upstream failure
+  resp = httptest.NewRecorder()
+  req, _ = http.NewRequest("POST", "/api/v1/users", bytes.NewReader([]byte(`{"provider": "facebok", "access_token": "brienne-token"}`)))
+  CreateUserHandler(resp, req)
+  assert.Equal(suite.T(), map[string]string{"provider": FieldInvalid}, fieldErrors(suite.T(), resp))
 }
 
 func (suite *SuiteTester) TestUserDeleteHandler() {
@@ -110,8 +144,25 @@ func (suite *SuiteTester) TestUserDeleteHandler() {
 }
 
 func (suite *SuiteTester) TestUserShowHandler() {
-  // test user is returned as expected
-  // test response code 200
+  tyrion := suite.users[0]
+  resp := httptest.NewRecorder()
+  req, err := http.NewRequest("GET", "/api/v1/users/"+tyrion.Id+"?:id="+tyrion.Id, nil)
+  assert.Nil(suite.T(), err)
+  ShowUserHandler(resp, req)
+  assert.Equal(suite.T(), 200, resp.Code)
+
+  res := struct {
+    User User `json:"user"`
+  }{}
+  err = json.Unmarshal(resp.Body.Bytes(), &res)
+  assert.Nil(suite.T(), err)
+  assert.Equal(suite.T(), tyrion.Id, res.User.Id)
+  assert.Equal(suite.T(), "Tyrion", res.User.FirstName)
+
+  resp = httptest.NewRecorder()
+  req, _ = http.NewRequest("GET", "/api/v1/users/missing?:id=missing", nil)
+  ShowUserHandler(resp, req)
+  assert.Equal(suite.T(), 404, resp.Code)
 }
 
 func (suite *SuiteTester) TestUserUpdateHandler() {
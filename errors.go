@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Error codes. Clients branch on these rather than on messages, so a code is
+// never renamed or reused. Refused participant transitions have their own
+// codes, see TransitionError.
+const (
+	CodeBadRequest           = "bad_request"            // malformed JSON, params or cursors
+	CodeUnauthorized         = "unauthorized"           // missing, unknown or expired session
+	CodeForbidden            = "forbidden"              // signed in but not allowed
+	CodeNotFound             = "not_found"              // no such resource, or one the user may not see
+	CodeMethodNotAllowed     = "method_not_allowed"     // the route exists, but not for this method
+	CodeValidationFailed     = "validation_failed"      // well formed, but a field has an invalid value
+	CodeConflict             = "conflict"               // clashes with the resource's current state
+	CodeGone                 = "gone"                   // existed once, like an expired invite link
+	CodeUpstream             = "upstream_failed"        // an identity provider couldn't be reached
+	CodeInternal             = "internal"               // a bug or an outage, details are only logged
+	CodeUnsupportedMediaType = "unsupported_media_type" // a PATCH body of a type other than a merge or JSON patch
+)
+
+// APIError is the body of every error response, as {"error": {...}}
+type APIError struct {
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestId string                 `json:"request_id"`
+}
+
+// requestIdHeader carries the request id on requests (from a proxy or the
+// client) and responses
+const requestIdHeader = "X-Request-Id"
+
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestId gives every request an id, the one it came with if it's
+// sane, so error responses and the server log can be matched up
+func withRequestId(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIdHeader)
+		if !validRequestId.MatchString(id) {
+			id = newId()
+		}
+		w.Header().Set(requestIdHeader, id)
+		h.ServeHTTP(w, req)
+	})
+}
+
+// sendError writes an error response. The request id is read back from the
+// response headers, so helpers without the request at hand can use it too.
+func sendError(w http.ResponseWriter, status int, code, message string, details map[string]interface{}) {
+	js, err := json.Marshal(map[string]APIError{"error": {
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestId: w.Header().Get(requestIdHeader),
+	}})
+	if err != nil {
+		errorf("Couldn't encode error %s: %v\n", code, err)
+		js = []byte(`{"error": {"code": "internal", "message": "Internal server error"}}`)
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+func badRequest(w http.ResponseWriter, message string) {
+	sendError(w, http.StatusBadRequest, CodeBadRequest, message, nil)
+}
+
+func forbidden(w http.ResponseWriter, message string) {
+	sendError(w, http.StatusForbidden, CodeForbidden, message, nil)
+}
+
+func notFound(w http.ResponseWriter, message string) {
+	sendError(w, http.StatusNotFound, CodeNotFound, message, nil)
+}
+
+func conflict(w http.ResponseWriter, message string) {
+	sendError(w, http.StatusConflict, CodeConflict, message, nil)
+}
+
+// internalError logs err and answers with a 500 that doesn't leak it, store
+// errors can carry queries and hostnames
+func internalError(w http.ResponseWriter, err error) {
+	errorf("Request %s failed: %v\n", w.Header().Get(requestIdHeader), err)
+	sendError(w, http.StatusInternalServerError, CodeInternal, "Internal server error", nil)
+}
+
+// routeNotFound answers requests no route matched
+func routeNotFound(w http.ResponseWriter, req *http.Request) {
+	notFound(w, "No route for "+req.Method+" "+req.URL.Path)
+}
+
+// methodNotAllowed answers requests to a route that only exists for the
+// allowed methods, which go in the Allow header and the error's details
+func methodNotAllowed(allowed []string) http.Handler {
+	allow := strings.Join(allowed, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Allow", allow)
+		sendError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, req.Method+" isn't allowed on "+req.URL.Path,
+			map[string]interface{}{"allowed": allowed})
+	})
+}
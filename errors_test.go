@@ -0,0 +1,79 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func decodeError(t *testing.T, resp *httptest.ResponseRecorder) APIError {
+  var body struct{ Error APIError }
+  assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+  assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &body), resp.Body.String())
+  return body.Error
+}
+
+func TestErrorEnvelope(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Brienne")
+
+  resp := doRequest("GET", "/api/v1/events/missing", sid, "")
+  assert.Equal(t, 404, resp.Code)
+  e := decodeError(t, resp)
+  assert.Equal(t, CodeNotFound, e.Code)
+  assert.NotEmpty(t, e.RequestId)
+  assert.Equal(t, resp.Header().Get("X-Request-Id"), e.RequestId)
+
+  resp = doRequest("POST", "/api/v1/users/" + user.Id + "/events", sid, `{"event": `)
+  assert.Equal(t, 400, resp.Code)
+  assert.Equal(t, CodeBadRequest, decodeError(t, resp).Code)
+
+  resp = doRequest("GET", "/api/v1/events?per=lots", "", "")
+  assert.Equal(t, CodeBadRequest, decodeError(t, resp).Code)
+
+  resp = doRequest("GET", "/api/v1/messages", "", "")
+  assert.Equal(t, 401, resp.Code)
+  assert.Equal(t, CodeUnauthorized, decodeError(t, resp).Code)
+
+  resp = doRequest("GET", "/nowhere", "", "")
+  assert.Equal(t, 404, resp.Code)
+  assert.Equal(t, CodeNotFound, decodeError(t, resp).Code)
+
+  // a route that exists, but not for this method
+  resp = doRequest("POST", "/api/v1/users/" + user.Id, sid, "")
+  assert.Equal(t, 405, resp.Code)
+  assert.Equal(t, "GET, HEAD, PUT, PATCH, DELETE", resp.Header().Get("Allow"))
+  e = decodeError(t, resp)
+  assert.Equal(t, CodeMethodNotAllowed, e.Code)
+  assert.Equal(t, resp.Header().Get("X-Request-Id"), e.RequestId)
+  assert.Equal(t, 405, doRequest("DELETE", "/ping", "", "").Code)
+}
+
+func TestRequestIdIsKept(t *testing.T) {
+  req, _ := http.NewRequest("GET", "/nowhere", nil)
+  req.Header.Set("X-Request-Id", "lb-1234")
+  resp := httptest.NewRecorder()
+  initRouting().ServeHTTP(resp, req)
+  assert.Equal(t, "lb-1234", decodeError(t, resp).RequestId)
+
+  // but not if it could mess up the logs
+  req.Header.Set("X-Request-Id", "a\nb")
+  resp = httptest.NewRecorder()
+  initRouting().ServeHTTP(resp, req)
+  assert.NotEqual(t, "a\nb", decodeError(t, resp).RequestId)
+}
+
+func TestInternalErrorsAreScrubbed(t *testing.T) {
+  resp := httptest.NewRecorder()
+  resp.Header().Set("X-Request-Id", "r1")
+  internalError(resp, errors.New("rethinkdb at 10.0.0.7:28015 refused the connection"))
+  assert.Equal(t, 500, resp.Code)
+  e := decodeError(t, resp)
+  assert.Equal(t, CodeInternal, e.Code)
+  assert.Equal(t, "r1", e.RequestId)
+  assert.NotContains(t, resp.Body.String(), "10.0.0.7")
+}
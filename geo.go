@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// earthRadiusKm is the mean radius of the Earth
+const earthRadiusKm = 6371.0088
+
+// haversineKm is the great circle distance between two points in km
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// validLatLon checks a coordinate is on the globe
+func validLatLon(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// Polygon is the coordinates of a GeoJSON Polygon: an outer ring followed by
+// any holes. Rings are closed lists of [lon, lat] positions.
+type Polygon [][][]float64
+
+// parsePolygon reads a GeoJSON Polygon geometry
+func parsePolygon(data string) (Polygon, error) {
+	var g struct {
+		Type        string  `json:"type"`
+		Coordinates Polygon `json:"coordinates"`
+	}
+	if err := json.Unmarshal([]byte(data), &g); err != nil {
+		return nil, errors.New("polygon must be a GeoJSON Polygon")
+	}
+	if g.Type != "Polygon" {
+		return nil, errors.New("polygon must be a GeoJSON Polygon")
+	}
+	if len(g.Coordinates) == 0 {
+		return nil, errors.New("polygon needs at least an outer ring")
+	}
+	for _, ring := range g.Coordinates {
+		if len(ring) < 4 {
+			return nil, errors.New("polygon rings need at least 4 positions")
+		}
+		for _, pos := range ring {
+			if len(pos) != 2 || !validLatLon(pos[1], pos[0]) {
+				return nil, errors.New("polygon positions must be [lon, lat] on the globe")
+			}
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return nil, errors.New("polygon rings must be closed")
+		}
+	}
+	return g.Coordinates, nil
+}
+
+// parseBbox reads a minLon,minLat,maxLon,maxLat bounding box. A box crossing
+// the antimeridian (minLon > maxLon) is split in two.
+func parseBbox(data string) ([]Polygon, error) {
+	parts := strings.Split(data, ",")
+	if len(parts) != 4 {
+		return nil, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	var b [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		b[i] = f
+	}
+	minLon, minLat, maxLon, maxLat := b[0], b[1], b[2], b[3]
+	if !validLatLon(minLat, minLon) || !validLatLon(maxLat, maxLon) {
+		return nil, errors.New("bbox must be within [-180, 180] longitude and [-90, 90] latitude")
+	}
+	if minLat > maxLat {
+		return nil, errors.New("bbox minLat must not be above maxLat")
+	}
+
+	if minLon > maxLon {
+		return []Polygon{box(minLon, minLat, 180, maxLat), box(-180, minLat, maxLon, maxLat)}, nil
+	}
+	return []Polygon{box(minLon, minLat, maxLon, maxLat)}, nil
+}
+
+func box(minLon, minLat, maxLon, maxLat float64) Polygon {
+	return Polygon{{
+		{minLon, minLat}, {maxLon, minLat}, {maxLon, maxLat}, {minLon, maxLat}, {minLon, minLat},
+	}}
+}
+
+// contains checks a [lon, lat] point is inside the outer ring and outside
+// every hole. Edges are treated as straight lines in lon/lat, which is what
+// a map viewport means.
+func (p Polygon) contains(lon, lat float64) bool {
+	if len(p) == 0 || !ringContains(p[0], lon, lat) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if ringContains(hole, lon, lat) {
+			return false
+		}
+	}
+	return true
+}
+
+// maxEdgeDegrees bounds the edges of areas sent to RethinkDB. It joins
+// vertices with geodesics where contains draws straight lon/lat lines; over
+// a degree the two are never more than about 120 m apart.
+const maxEdgeDegrees = 1.0
+
+// densify returns p with vertices added along its edges so that none spans
+// more than maxStep degrees of longitude or latitude. The straight lon/lat
+// lines between them stay where they were.
+func (p Polygon) densify(maxStep float64) Polygon {
+	dense := make(Polygon, len(p))
+	for i, ring := range p {
+		for j := 0; j+1 < len(ring); j++ {
+			a, b := ring[j], ring[j+1]
+			n := int(math.Max(1, math.Ceil(math.Max(math.Abs(b[0]-a[0]), math.Abs(b[1]-a[1]))/maxStep)))
+			for k := 0; k < n; k++ {
+				t := float64(k) / float64(n)
+				dense[i] = append(dense[i], []float64{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t})
+			}
+		}
+		dense[i] = append(dense[i], ring[len(ring)-1])
+	}
+	return dense
+}
+
+// ringContains is a ray casting point in polygon test. Points on the
+// boundary count as inside.
+func ringContains(ring [][]float64, lon, lat float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if onSegment(xi, yi, xj, yj, lon, lat) {
+			return true
+		}
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+func onSegment(x1, y1, x2, y2, x, y float64) bool {
+	cross := (x2-x1)*(y-y1) - (y2-y1)*(x-x1)
+	if math.Abs(cross) > 1e-12 {
+		return false
+	}
+	return x >= math.Min(x1, x2) && x <= math.Max(x1, x2) && y >= math.Min(y1, y2) && y <= math.Max(y1, y2)
+}
+
+//// GeoJSON output
+
+// FeatureCollection is the GeoJSON representation of a list of events. Page,
+// Per, Next and Prev are foreign members, as in the {"events": [...]} envelope.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+	Page     string    `json:"page"`
+	Per      string    `json:"per"`
+	Next     *string   `json:"next"`
+	Prev     *string   `json:"prev"`
+}
+
+// Feature is an Event as GeoJSON. Events without a location have a null geometry.
+type Feature struct {
+	Type       string                 `json:"type"`
+	Id         string                 `json:"id"`
+	Geometry   *Point                 `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Point is a GeoJSON Point geometry
+type Point struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// eventFeature turns an Event, or a NearbyEvent, into a Feature. Every JSON
+// field of the event except its location becomes a property.
+func eventFeature(v interface{}) (Feature, error) {
+	var e Event
+	switch ev := v.(type) {
+	case Event:
+		e = ev
+	case NearbyEvent:
+		e = ev.Event
+	default:
+		return Feature{}, fmt.Errorf("can't make a feature of %T", v)
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return Feature{}, err
+	}
+	props := map[string]interface{}{}
+	if err := json.Unmarshal(js, &props); err != nil {
+		return Feature{}, err
+	}
+	delete(props, "location")
+	delete(props, "lon")
+	delete(props, "lat")
+
+	f := Feature{Type: "Feature", Id: e.Id, Properties: props}
+	if len(e.Location) == 2 {
+		f.Geometry = &Point{Type: "Point", Coordinates: []float64{e.Location[0], e.Location[1]}}
+	}
+	return f, nil
+}
+
+// wantsGeoJson checks whether the client asked for GeoJSON, with
+// format=geojson or an Accept header of application/geo+json
+func wantsGeoJson(req *http.Request) bool {
+	if req.URL.Query().Get("format") == "geojson" {
+		return true
+	}
+	return strings.Contains(req.Header.Get("Accept"), "application/geo+json")
+}
+
+func sendGeoJson(v interface{}, w http.ResponseWriter) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Write(js)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestHaversine(t *testing.T) {
+  // Philadelphia City Hall to the Empire State Building
+  d := haversineKm(39.9524, -75.1636, 40.7484, -73.9857)
+  assert.InDelta(t, 133.4, d, 0.5)
+  assert.Equal(t, 0.0, haversineKm(10, 20, 10, 20))
+  // antipodes
+  assert.InDelta(t, 20015.1, haversineKm(0, 0, 0, 180), 0.5)
+}
+
+func nearbyFixture(t *testing.T) {
+  store = NewMemoryStore()
+  host, _ := newTestUser(t, "Samwell")
+  for _, e := range []Event{
+    {Title: "Camden", Location: Location{-75.1196, 39.9259}},        // ~4.6km
+    {Title: "City Hall", Location: Location{-75.1636, 39.9524}},     // 0km
+    {Title: "Trenton", Location: Location{-74.7597, 40.2206}},       // ~45km
+    {Title: "Secret", Location: Location{-75.1640, 39.9530}, PrivacyLevel: PrivacyPrivate},
+    {Title: "Nowhere"},
+  } {
+    e.UserId = host.Id
+    store.CreateEvent(e)
+  }
+}
+
+func TestMemoryStoreNearbyEvents(t *testing.T) {
+  nearbyFixture(t)
+
+  events, err := store.ListNearbyEvents(39.9524, -75.1636, 10, listedPrivacyLevels, 1, 20)
+  assert.Nil(t, err)
+  assert.Equal(t, 2, len(events))
+  assert.Equal(t, "City Hall", events[0].Title)
+  assert.Equal(t, "Camden", events[1].Title)
+  assert.InDelta(t, 4.6, events[1].DistanceKm, 0.2)
+
+  events, _ = store.ListNearbyEvents(39.9524, -75.1636, 100, listedPrivacyLevels, 2, 2)
+  assert.Equal(t, 1, len(events))
+  assert.Equal(t, "Trenton", events[0].Title)
+}
+
+func TestIndexEventsNear(t *testing.T) {
+  nearbyFixture(t)
+
+  resp := doRequest("GET", "/api/v1/events?lat=39.9524&lon=-75.1636&radius_km=100", "", "")
+  assert.Equal(t, 200, resp.Code)
+  res := struct {
+    Events []NearbyEvent `json:"events"`
+  }{}
+  json.Unmarshal(resp.Body.Bytes(), &res)
+  assert.Equal(t, 3, len(res.Events))
+  assert.Equal(t, "Trenton", res.Events[2].Title)
+  assert.InDelta(t, 45, res.Events[2].DistanceKm, 2)
+  assert.Contains(t, resp.Body.String(), `"distance_km"`)
+
+  // the radius is capped at geo.max_radius_km
+  resp = doRequest("GET", "/api/v1/events?lat=0&lon=0&radius_km=100000", "", "")
+  assert.Equal(t, 200, resp.Code)
+  assert.Contains(t, resp.Body.String(), `"events":[]`)
+
+  for _, q := range []string{"lat=39.9", "lat=91&lon=0", "lat=0&lon=181", "lat=x&lon=0", "lat=0&lon=0&radius_km=-1"} {
+    resp = doRequest("GET", "/api/v1/events?" + q, "", "")
+    assert.Equal(t, 400, resp.Code, q)
+  }
+}
+
+func TestParseBbox(t *testing.T) {
+  areas, err := parseBbox("-75.3,39.8,-74.9,40.1")
+  assert.Nil(t, err)
+  assert.Equal(t, 1, len(areas))
+  assert.True(t, areas[0].contains(-75.1636, 39.9524))
+  assert.False(t, areas[0].contains(-74.7597, 40.2206))
+
+  // crossing the antimeridian
+  areas, err = parseBbox("170,-20,-170,20")
+  assert.Nil(t, err)
+  assert.Equal(t, 2, len(areas))
+  assert.True(t, areas[0].contains(175, 0) || areas[1].contains(175, 0))
+  assert.True(t, areas[0].contains(-175, 0) || areas[1].contains(-175, 0))
+  assert.False(t, areas[0].contains(0, 0) || areas[1].contains(0, 0))
+
+  for _, bad := range []string{"", "1,2,3", "a,b,c,d", "0,50,10,40", "-190,0,0,10", "0,0,10,95"} {
+    _, err := parseBbox(bad)
+    assert.NotNil(t, err, bad)
+  }
+}
+
+func TestDensify(t *testing.T) {
+  areas, _ := parseBbox("170,-20,-170,20")
+  dense := areas[0].densify(maxEdgeDegrees)
+  // 10 + 40 + 10 + 40 one degree edges, closed
+  assert.Equal(t, 101, len(dense[0]))
+  assert.Equal(t, []float64{171, -20}, dense[0][1])
+  assert.Equal(t, dense[0][0], dense[0][100])
+  for _, point := range [][]float64{{175, 0}, {170, 20}, {180, -20}, {169.9, 0}, {175, 20.1}} {
+    assert.Equal(t, areas[0].contains(point[0], point[1]), dense.contains(point[0], point[1]), point)
+  }
+
+  // short edges are left alone
+  small := box(0, 0, 0.5, 0.5)
+  assert.Equal(t, small, small.densify(maxEdgeDegrees))
+}
+
+func TestParsePolygon(t *testing.T) {
+  // a square with a square hole
+  p, err := parsePolygon(`{"type": "Polygon", "coordinates": [
+    [[0,0], [10,0], [10,10], [0,10], [0,0]],
+    [[4,4], [6,4], [6,6], [4,6], [4,4]]
+  ]}`)
+  assert.Nil(t, err)
+  assert.True(t, p.contains(2, 2))
+  assert.True(t, p.contains(0, 5)) // on the edge
+  assert.False(t, p.contains(5, 5))
+  assert.False(t, p.contains(11, 5))
+
+  for _, bad := range []string{
+    `nope`,
+    `{"type": "Point", "coordinates": [0, 0]}`,
+    `{"type": "Polygon", "coordinates": []}`,
+    `{"type": "Polygon", "coordinates": [[[0,0], [1,0], [0,0]]]}`,
+    `{"type": "Polygon", "coordinates": [[[0,0], [1,0], [1,1], [0,1]]]}`,
+    `{"type": "Polygon", "coordinates": [[[0,0], [1,0], [1,100], [0,0]]]}`,
+  } {
+    _, err := parsePolygon(bad)
+    assert.NotNil(t, err, bad)
+  }
+}
+
+func TestIndexEventsWithin(t *testing.T) {
+  nearbyFixture(t)
+
+  resp := doRequest("GET", "/api/v1/events?bbox=-75.3,39.8,-75.0,40.0", "", "")
+  assert.Equal(t, 200, resp.Code)
+  res := struct {
+    Events []Event `json:"events"`
+  }{}
+  json.Unmarshal(resp.Body.Bytes(), &res)
+  assert.Equal(t, 2, len(res.Events)) // Camden & City Hall, not the private one
+
+  polygon := url.QueryEscape(`{"type": "Polygon", "coordinates": [[[-75,40], [-74,40], [-74,41], [-75,41], [-75,40]]]}`)
+  resp = doRequest("GET", "/api/v1/events?polygon=" + polygon, "", "")
+  assert.Equal(t, 200, resp.Code)
+  json.Unmarshal(resp.Body.Bytes(), &res)
+  assert.Equal(t, 1, len(res.Events))
+  assert.Equal(t, "Trenton", res.Events[0].Title)
+
+  // on the antimeridian both halves of the bbox have it, it's listed once
+  store.CreateEvent(Event{Title: "Taveuni", Location: Location{180, -16.8}})
+  resp = doRequest("GET", "/api/v1/events?bbox=170,-20,-170,20", "", "")
+  json.Unmarshal(resp.Body.Bytes(), &res)
+  if assert.Equal(t, 1, len(res.Events)) {
+    assert.Equal(t, "Taveuni", res.Events[0].Title)
+  }
+
+  resp = doRequest("GET", "/api/v1/events?bbox=-75.3,39.8,-75.0,40.0&lat=1&lon=1", "", "")
+  assert.Equal(t, 400, resp.Code)
+  resp = doRequest("GET", "/api/v1/events?polygon=nope", "", "")
+  assert.Equal(t, 400, resp.Code)
+}
+
+func TestIndexEventsGeoJson(t *testing.T) {
+  nearbyFixture(t)
+
+  resp := doRequest("GET", "/api/v1/events?lat=39.9524&lon=-75.1636&radius_km=10&format=geojson", "", "")
+  assert.Equal(t, 200, resp.Code)
+  assert.Equal(t, "application/geo+json", resp.Header().Get("Content-Type"))
+
+  fc := FeatureCollection{}
+  assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &fc))
+  assert.Equal(t, "FeatureCollection", fc.Type)
+  assert.Equal(t, 2, len(fc.Features))
+  f := fc.Features[0]
+  assert.Equal(t, "Feature", f.Type)
+  assert.Equal(t, "Point", f.Geometry.Type)
+  assert.Equal(t, []float64{-75.1636, 39.9524}, f.Geometry.Coordinates)
+  assert.Equal(t, "City Hall", f.Properties["title"])
+  assert.Contains(t, f.Properties, "distance_km")
+  assert.NotContains(t, f.Properties, "location")
+
+  // events without a location have a null geometry
+  req, _ := http.NewRequest("GET", "/api/v1/events", nil)
+  req.Header.Set("Accept", "application/geo+json")
+  rec := httptest.NewRecorder()
+  initRouting().ServeHTTP(rec, req)
+  assert.Contains(t, rec.Body.String(), `"geometry":null`)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+)
+
+// Hub is an in-process publish/subscribe hub. Subscribers receive what is
+// published to their topic after they subscribed. It backs the streams when
+// there's no database changefeed, so it only reaches clients connected to
+// this process.
+type Hub struct {
+	mu     sync.Mutex
+	topics map[string]map[chan interface{}]bool
+}
+
+func NewHub() *Hub {
+	return &Hub{topics: map[string]map[chan interface{}]bool{}}
+}
+
+// Subscribe returns a channel receiving the topic's values. A subscriber that
+// falls more than buffer values behind is dropped and its channel closed.
+func (h *Hub) Subscribe(topic string, buffer int) chan interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan interface{}, buffer)
+	if h.topics[topic] == nil {
+		h.topics[topic] = map[chan interface{}]bool{}
+	}
+	h.topics[topic][ch] = true
+	return ch
+}
+
+// Unsubscribe stops delivery to ch and closes it
+func (h *Hub) Unsubscribe(topic string, ch chan interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(topic, ch)
+}
+
+// Publish sends v to every subscriber of topic without blocking
+func (h *Hub) Publish(topic string, v interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.topics[topic] {
+		select {
+		case ch <- v:
+		default:
+			h.remove(topic, ch)
+		}
+	}
+}
+
+func (h *Hub) remove(topic string, ch chan interface{}) {
+	subs := h.topics[topic]
+	if !subs[ch] {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(h.topics, topic)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned by an IdentityProvider that rejects an access token
+var ErrInvalidToken = errors.New("invalid access token")
+
+// Identity is a user as vouched for by an IdentityProvider
+type Identity struct {
+	Provider  string
+	Subject   string // the provider's id for the user, e.g. the Facebook user id
+	FirstName string
+	LastName  string
+	Email     string
+}
+
+// identityUserId is the id of the user created for identity
+func identityUserId(identity Identity) string {
+	return nameId(identity.Provider + "/" + identity.Subject)
+}
+
+// IdentityProvider verifies an access token the client obtained from a
+// third party and returns who it belongs to
+type IdentityProvider interface {
+	Name() string
+	Verify(accessToken string) (Identity, error)
+}
+
+var identityProviders = map[string]IdentityProvider{}
+
+func RegisterIdentityProvider(p IdentityProvider) {
+	identityProviders[p.Name()] = p
+}
+
+// configureIdentityProviders registers the providers enabled in the config
+func configureIdentityProviders(c *Config) {
+	identityProviders = map[string]IdentityProvider{}
+	if c.Auth.FakeFacebook {
+		warnf("Accepting fake Facebook tokens, never run like this in production\n")
+		RegisterIdentityProvider(NewFakeIdentityProvider("facebook"))
+	} else if c.Auth.FacebookAppId != "" {
+		RegisterIdentityProvider(&FacebookProvider{
+			AppId:     c.Auth.FacebookAppId,
+			AppSecret: c.Auth.FacebookAppSecret,
+			GraphURL:  c.Auth.FacebookGraphURL,
+			Client:    &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+	if len(identityProviders) == 0 {
+		warnf("No identity providers configured, users won't be able to sign in\n")
+	}
+}
+
+// validateProvider checks provider is registered, so a typo is the
+// client's 422 rather than an upstream failure
+func validateProvider(provider string, v *Validation) {
+	if _, ok := identityProviders[provider]; ok {
+		return
+	}
+	names := []string{}
+	for name := range identityProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		v.Add("provider", FieldInvalid, fmt.Sprintf("provider %q isn't supported, no identity providers are configured", provider))
+		return
+	}
+	v.Add("provider", FieldInvalid, "provider must be one of "+strings.Join(names, ", "))
+}
+
+// verifyIdentity looks up the named provider and verifies the token with it
+func verifyIdentity(provider, accessToken string) (Identity, error) {
+	p, ok := identityProviders[provider]
+	if !ok {
+		return Identity{}, fmt.Errorf("unknown identity provider %q", provider)
+	}
+	return p.Verify(accessToken)
+}
+
+//// Facebook
+
+// FacebookProvider checks user access tokens with the Graph API's debug_token
+// endpoint, making sure they were issued to our app, then reads the profile.
+type FacebookProvider struct {
+	AppId     string
+	AppSecret string
+	GraphURL  string // defaults to https://graph.facebook.com
+	Client    *http.Client
+}
+
+func (p *FacebookProvider) Name() string {
+	return "facebook"
+}
+
+func (p *FacebookProvider) Verify(accessToken string) (Identity, error) {
+	var debug struct {
+		Data struct {
+			AppId     string `json:"app_id"`
+			UserId    string `json:"user_id"`
+			IsValid   bool   `json:"is_valid"`
+			ExpiresAt int64  `json:"expires_at"`
+		} `json:"data"`
+	}
+	err := p.get("/debug_token", url.Values{
+		"input_token":  {accessToken},
+		"access_token": {p.AppId + "|" + p.AppSecret},
+	}, &debug)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	d := debug.Data
+	if !d.IsValid || d.UserId == "" || d.AppId != p.AppId {
+		return Identity{}, ErrInvalidToken
+	}
+	if d.ExpiresAt != 0 && time.Unix(d.ExpiresAt, 0).Before(time.Now()) {
+		return Identity{}, ErrInvalidToken
+	}
+
+	var me struct {
+		Id        string `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+	}
+	err = p.get("/me", url.Values{
+		"fields":       {"id,first_name,last_name,email"},
+		"access_token": {accessToken},
+	}, &me)
+	if err != nil {
+		return Identity{}, err
+	}
+	if me.Id != d.UserId {
+		return Identity{}, ErrInvalidToken
+	}
+
+	return Identity{
+		Provider:  p.Name(),
+		Subject:   d.UserId,
+		FirstName: me.FirstName,
+		LastName:  me.LastName,
+		Email:     me.Email,
+	}, nil
+}
+
+func (p *FacebookProvider) get(path string, params url.Values, v interface{}) error {
+	base := p.GraphURL
+	if base == "" {
+		base = "https://graph.facebook.com"
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(strings.TrimRight(base, "/") + path + "?" + params.Encode())
+	if err != nil {
+		return fmt.Errorf("facebook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Graph reports bad tokens as 400s with an error body
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return ErrInvalidToken
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("facebook: %s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+//// Fake
+
+// FakeIdentityProvider accepts tokens of the form "fake:<subject>" plus any
+// registered in Tokens. It's for tests and local development only.
+type FakeIdentityProvider struct {
+	ProviderName string
+	Tokens       map[string]Identity
+}
+
+func NewFakeIdentityProvider(name string) *FakeIdentityProvider {
+	return &FakeIdentityProvider{ProviderName: name, Tokens: map[string]Identity{}}
+}
+
+func (p *FakeIdentityProvider) Name() string {
+	return p.ProviderName
+}
+
+func (p *FakeIdentityProvider) Verify(accessToken string) (Identity, error) {
+	if id, ok := p.Tokens[accessToken]; ok {
+		id.Provider = p.ProviderName
+		return id, nil
+	}
+	if strings.HasPrefix(accessToken, "fake:") && len(accessToken) > len("fake:") {
+		return Identity{Provider: p.ProviderName, Subject: strings.TrimPrefix(accessToken, "fake:")}, nil
+	}
+	return Identity{}, ErrInvalidToken
+}
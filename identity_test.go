@@ -0,0 +1,93 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "sync"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+// fakeGraph answers debug_token and /me like the Graph API for a single valid token
+func fakeGraph(t *testing.T, appId string) *httptest.Server {
+  return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    q := req.URL.Query()
+    switch req.URL.Path {
+    case "/debug_token":
+      assert.Equal(t, appId+"|secret", q.Get("access_token"))
+      valid := q.Get("input_token") == "good" || q.Get("input_token") == "other-app"
+      app := appId
+      if q.Get("input_token") == "other-app" {
+        app = "someone-else"
+      }
+      json.NewEncoder(w).Encode(map[string]interface{}{
+        "data": map[string]interface{}{"app_id": app, "user_id": "10153", "is_valid": valid},
+      })
+    case "/me":
+      if q.Get("access_token") != "good" {
+        w.WriteHeader(http.StatusBadRequest)
+        return
+      }
+      json.NewEncoder(w).Encode(map[string]string{"id": "10153", "first_name": "Davos", "last_name": "Seaworth"})
+    default:
+      http.NotFound(w, req)
+    }
+  }))
+}
+
+func TestFacebookProviderVerify(t *testing.T) {
+  graph := fakeGraph(t, "app1")
+  defer graph.Close()
+  p := &FacebookProvider{AppId: "app1", AppSecret: "secret", GraphURL: graph.URL}
+
+  id, err := p.Verify("good")
+  assert.Nil(t, err)
+  assert.Equal(t, "facebook", id.Provider)
+  assert.Equal(t, "10153", id.Subject)
+  assert.Equal(t, "Davos", id.FirstName)
+
+  _, err = p.Verify("bad")
+  assert.Equal(t, ErrInvalidToken, err)
+
+  // valid tokens issued to another app must not sign anyone in here
+  _, err = p.Verify("other-app")
+  assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestFakeIdentityProvider(t *testing.T) {
+  p := NewFakeIdentityProvider("facebook")
+  id, err := p.Verify("fake:42")
+  assert.Nil(t, err)
+  assert.Equal(t, "42", id.Subject)
+
+  _, err = p.Verify("fake:")
+  assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestConcurrentSignInsCreateOneUser(t *testing.T) {
+  store = NewMemoryStore()
+  identity := Identity{Provider: "facebook", Subject: "77", FirstName: "Arya"}
+
+  ids := make([]string, 20)
+  var wg sync.WaitGroup
+  for i := range ids {
+    wg.Add(1)
+    go func(i int) {
+      defer wg.Done()
+      user, err := findOrCreateUserForIdentity(identity, User{})
+      assert.Nil(t, err)
+      ids[i] = user.Id
+    }(i)
+  }
+  wg.Wait()
+
+  for _, id := range ids {
+    assert.Equal(t, identityUserId(identity), id)
+  }
+  users, _ := store.ListUsers(Page{Number: 1, Per: 50})
+  assert.Equal(t, 1, len(users))
+  user, _ := store.FindUserByFacebookId("77")
+  assert.Equal(t, "Arya", user.FirstName)
+}
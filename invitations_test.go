@@ -0,0 +1,125 @@
+package main
+
+import (
+  "encoding/json"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestInviteByEmail(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyInviteOnly)
+  guest, guest_sid := newTestUser(t, "Tyrion")
+  guest.Email = "tyrion@example.com"
+  store.UpdateUser(guest)
+  url := "/api/v1/events/" + f.event.Id + "/invitations"
+
+  assert.Equal(t, 404, doRequest("GET", "/api/v1/events/" + f.event.Id, guest_sid, "").Code)
+
+  // only the host invites
+  assert.Equal(t, 404, doRequest("POST", url, f.stranger, `{"invitation": {"email": "tyrion@example.com"}}`).Code)
+  assert.Equal(t, 403, doRequest("POST", url, f.member, `{"invitation": {"email": "tyrion@example.com"}}`).Code)
+  assert.Equal(t, 422, doRequest("POST", url, f.host, `{"invitation": {}}`).Code)
+  assert.Equal(t, 404, doRequest("POST", url, f.host, `{"invitation": {"user_id": "imp"}}`).Code)
+
+  // an email answers the same whether or not it has an account
+  unknown := doRequest("POST", url, f.host, `{"invitation": {"email": "imp@example.com"}}`)
+  res := doRequest("POST", url, f.host, `{"invitation": {"email": "tyrion@example.com"}}`)
+  assert.Equal(t, 202, unknown.Code)
+  assert.Equal(t, 202, res.Code)
+  assert.Equal(t, unknown.Body.String(), strings.Replace(res.Body.String(), "tyrion", "imp", 1))
+
+  var body struct {
+    Participant ParticipantWrite
+    Created     bool
+  }
+  json.Unmarshal(doRequest("POST", url, f.host, `{"invitation": {"user_id": "` + guest.Id + `"}}`).Body.Bytes(), &body)
+  assert.False(t, body.Created)
+  assert.Equal(t, ParticipantInvited, body.Participant.Status)
+
+  // the invitee sees the event, but not its messages until they accept
+  assert.Equal(t, 200, doRequest("GET", "/api/v1/events/" + f.event.Id, guest_sid, "").Code)
+  assert.Equal(t, 403, doRequest("GET", "/api/v1/events/" + f.event.Id + "/messages", guest_sid, "").Code)
+  // the host can't accept for them
+  assert.Equal(t, 409, doRequest("PUT", "/api/v1/participants/" + body.Participant.Id, f.host, `{"participant": {"status": "accepted"}}`).Code)
+  assert.Equal(t, 200, doRequest("PUT", "/api/v1/participants/" + body.Participant.Id, guest_sid, `{"participant": {"status": "accepted"}}`).Code)
+  assert.Equal(t, 200, doRequest("GET", "/api/v1/events/" + f.event.Id + "/messages", guest_sid, "").Code)
+
+  // inviting again changes nothing
+  json.Unmarshal(doRequest("POST", url, f.host, `{"invitation": {"user_id": "` + guest.Id + `"}}`).Body.Bytes(), &body)
+  assert.False(t, body.Created)
+  assert.Equal(t, ParticipantAccepted, body.Participant.Status)
+
+  notifications, _ := store.ListUserNotifications(guest.Id, "", 0)
+  assert.Equal(t, NotifyParticipantInvited, notifications[0].Type)
+}
+
+func TestInviteLinks(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyInviteOnly)
+  guest, guest_sid := newTestUser(t, "Tyrion")
+
+  var body struct {
+    InviteLink InviteLink `json:"invite_link"`
+    Url        string
+    Participant ParticipantWrite
+  }
+  assert.Equal(t, 403, doRequest("POST", "/api/v1/events/" + f.event.Id + "/invite_links", f.member, "").Code)
+  res := doRequest("POST", "/api/v1/events/" + f.event.Id + "/invite_links", f.host, `{"invite_link": {"expires_in": "1h"}}`)
+  assert.Equal(t, 200, res.Code)
+  json.Unmarshal(res.Body.Bytes(), &body)
+  assert.Equal(t, "/api/v1/invite_links/" + body.InviteLink.Id, body.Url)
+  assert.WithinDuration(t, time.Now().Add(time.Hour), body.InviteLink.ExpiresAt, time.Minute)
+
+  res = doRequest("POST", body.Url, guest_sid, "")
+  assert.Equal(t, 200, res.Code)
+  json.Unmarshal(res.Body.Bytes(), &body)
+  assert.Equal(t, ParticipantInvited, body.Participant.Status)
+  assert.Equal(t, f.event.UserId, body.Participant.Transitions[0].ActorId)
+
+  // the host hears about it, the guest is told they're invited
+  notifications, _ := store.ListUserNotifications(f.event.UserId, "", 0)
+  redeemed := notifications[len(notifications) - 1]
+  assert.Equal(t, NotifyInviteLinkRedeemed, redeemed.Type)
+  assert.Equal(t, guest.Id, redeemed.ActorId)
+  notifications, _ = store.ListUserNotifications(guest.Id, "", 0)
+  assert.Equal(t, NotifyParticipantInvited, notifications[0].Type)
+
+  // redeeming again changes nothing and tells nobody
+  assert.Equal(t, 200, doRequest("POST", body.Url, guest_sid, "").Code)
+  notifications, _ = store.ListUserNotifications(f.event.UserId, redeemed.Id, 0)
+  assert.Empty(t, notifications)
+  assert.Equal(t, 200, doRequest("GET", "/api/v1/events/" + f.event.Id, guest_sid, "").Code)
+
+  // expired links don't work
+  expired, _ := store.CreateInviteLink(InviteLink{EventId: f.event.Id, ExpiresAt: time.Now().Add(-time.Minute)})
+  assert.Equal(t, 410, doRequest("POST", "/api/v1/invite_links/" + expired.Id, f.stranger, "").Code)
+
+  // nor revoked ones
+  assert.Equal(t, 404, doRequest("DELETE", body.Url, f.member, "").Code)
+  assert.Equal(t, 200, doRequest("DELETE", body.Url, f.host, "").Code)
+  assert.Equal(t, 404, doRequest("POST", body.Url, f.stranger, "").Code)
+
+  f = newPrivacyFixture(t, PrivacyPrivate)
+  assert.Equal(t, 400, doRequest("POST", "/api/v1/events/" + f.event.Id + "/invite_links", f.host, "").Code)
+}
+
+func TestDeletesRemoveInviteLinks(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyInviteOnly)
+  url := "/api/v1/events/" + f.event.Id + "/invite_links"
+  var body struct{ InviteLink InviteLink `json:"invite_link"` }
+
+  // a deleted event's links go
+  json.Unmarshal(doRequest("POST", url, f.host, "").Body.Bytes(), &body)
+  assert.NotEmpty(t, body.InviteLink.Id)
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/users/" + f.event.UserId + "/events/" + f.event.Id, f.host, "").Code)
+  _, err := store.FindInviteLink(body.InviteLink.Id)
+  assert.Equal(t, ErrNotFound, err)
+
+  // as do a deleted user's
+  link, _ := store.CreateInviteLink(InviteLink{EventId: "gone", UserId: f.event.UserId})
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/users/" + f.event.UserId, f.host, "").Code)
+  _, err = store.FindInviteLink(link.Id)
+  assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Log levels. The standard logger used throughout the handlers logs at info.
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevels = map[string]int{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+var (
+	logLevel = LevelInfo
+	// errLog bypasses the level gate on the standard logger
+	errLog = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+func SetLogLevel(name string) error {
+	level, ok := logLevels[name]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", name)
+	}
+	logLevel = level
+
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+	switch {
+	case level == LevelDebug:
+		log.SetFlags(log.LstdFlags | log.Lshortfile)
+	case level > LevelInfo:
+		log.SetOutput(ioutil.Discard)
+	}
+	return nil
+}
+
+// debugf logs request details that are too noisy (or too sensitive) for info
+func debugf(format string, v ...interface{}) {
+	if logLevel <= LevelDebug {
+		log.Output(2, fmt.Sprintf(format, v...))
+	}
+}
+
+func warnf(format string, v ...interface{}) {
+	if logLevel <= LevelWarn {
+		errLog.Printf("WARN "+format, v...)
+	}
+}
+
+func errorf(format string, v ...interface{}) {
+	errLog.Printf("ERROR "+format, v...)
+}
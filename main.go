@@ -1,19 +1,57 @@
 package main
 
 import (
-	"flag"
   "log"
+	"os"
 )
 
+// Usage:
+//   goreson [flags]                  start the API server
+//   goreson [flags] migrate          apply pending database migrations
+//   goreson [flags] migrate status   list pending database migrations
+//
+// Run `goreson -h` for the flags. Every flag can also be set with an
+// environment variable or in the -config file.
 func main() {
-	var (
-		addr string = "0.0.0.0:3000"
-	)
+  log.Println("Loading configuration")
+	cfg, args, err := LoadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	config = cfg
+	SetLogLevel(config.LogLevel)
+	configureIdentityProviders(config)
 
-  log.Println("Parsing command arguments")
-	flag.StringVar(&addr, "addr", "0.0.0.0:3000", "")
-	flag.Parse()
+	log.Println("Starting up")
+	switch config.Store {
+	case "rethink":
+		opts, err := config.ConnectOpts()
+		if err != nil {
+			errLog.Fatalln(err.Error())
+		}
+		store, err = NewRethinkStore(opts)
+		if err != nil {
+			errLog.Fatalln(err.Error())
+		}
+	case "memory":
+		log.Println("Using in-memory store, nothing will be persisted")
+		store = NewMemoryStore()
+	}
+	defer store.Close()
 
-	server := NewServer(addr)
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(store, args[1:]); err != nil {
+			errLog.Fatalln(err.Error())
+		}
+		return
+	}
+
+	if err := checkMigrations(store); err != nil {
+		errLog.Fatalln(err.Error())
+	}
+
+	go pruneNotifications(config.NotificationRetention.Duration)
+
+	server := NewServer(config.Addr)
 	StartServer(server)
 }
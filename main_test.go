@@ -1,15 +1,22 @@
 package main
 
 import (
-  r "github.com/dancannon/gorethink"
+  "bytes"
   "fmt"
-  "log"
+  "os"
+  "time"
   "net/http"
   "net/http/httptest"
   "github.com/stretchr/testify/assert"
   "testing"
 )
 
+// Tests run against the in-memory store so no database is needed
+func TestMain(m *testing.M) {
+  store = NewMemoryStore()
+  os.Exit(m.Run())
+}
+
 func TestUsersIndex (t *testing.T) {
   recorder := httptest.NewRecorder()
   req, err := http.NewRequest("GET", "/api/v1/users", nil)
@@ -31,20 +38,24 @@ func TestUsersIndexXXX (t *testing.T) {
   assert.Equal(t, 200, resp.StatusCode)
 }
 
-func InitTestDB() *r.Session {
-  session, err := r.Connect(r.ConnectOpts{
-    Address: "localhost:28015",
-    Database: "gadder_test",
-  })
+// newTestUser creates a user with a signed in session and returns both
+func newTestUser(t *testing.T, first_name string) (User, string) {
+  now := time.Now()
+  user, err := store.CreateUser(User{FirstName: first_name, FacebookId: "fb-" + first_name, CreatedAt: now, UpdatedAt: now})
+  assert.Nil(t, err)
+  s, err := startSession(user.Id, Device{})
+  assert.Nil(t, err)
+  return user, s.Id
+}
 
-  if err != nil {
-    log.Println(err)
-  }
-  r.DbDrop("gadder_test").Exec(session)
-  err = r.DbCreate("gadder_test").Exec(session)
-  if err != nil {
-    log.Println(err)
+// doRequest sends a request through the full router, as a client would.
+// A non empty sid is sent as a Bearer token.
+func doRequest(method, url, sid, body string) *httptest.ResponseRecorder {
+  resp := httptest.NewRecorder()
+  req, _ := http.NewRequest(method, url, bytes.NewReader([]byte(body)))
+  if sid != "" {
+    req.Header.Set("Authorization", "Bearer " + sid)
   }
-
-  return session
+  initRouting().ServeHTTP(resp, req)
+  return resp
 }
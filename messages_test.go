@@ -0,0 +1,87 @@
+package main
+
+import (
+  "encoding/json"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestMessageRevisions(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  message := postMessage(t, f, f.member, "Khaleesi", "")
+  assert.Nil(t, message.EditedAt)
+  url := "/api/v1/messages/" + message.Id
+
+  // the same content isn't an edit
+  var body struct {
+    Message   Message
+    Changed   bool
+    Revisions []MessageRevision
+  }
+  json.Unmarshal(doRequest("PUT", url, f.member, `{"message": {"content": "Khaleesi"}}`).Body.Bytes(), &body)
+  assert.False(t, body.Changed)
+
+  json.Unmarshal(doRequest("PUT", url, f.member, `{"message": {"content": "My queen"}}`).Body.Bytes(), &body)
+  assert.True(t, body.Changed)
+  assert.NotNil(t, body.Message.EditedAt)
+  doRequest("PUT", url, f.member, `{"message": {"content": "Mhysa"}}`)
+
+  // only the author and the host see revisions
+  assert.Equal(t, 403, doRequest("GET", url + "/revisions", f.stranger, "").Code)
+  assert.Equal(t, 200, doRequest("GET", url + "/revisions", f.member, "").Code)
+  res := doRequest("GET", url + "/revisions", f.host, "")
+  assert.Equal(t, 200, res.Code)
+  json.Unmarshal(res.Body.Bytes(), &body)
+  assert.Equal(t, "Mhysa", body.Message.Content)
+  if assert.Len(t, body.Revisions, 2) {
+    assert.Equal(t, "Khaleesi", body.Revisions[0].Content)
+    assert.Equal(t, message.CreatedAt.Unix(), body.Revisions[0].CreatedAt.Unix())
+    assert.Equal(t, "My queen", body.Revisions[1].Content)
+    assert.Equal(t, body.Revisions[0].ReplacedAt, body.Revisions[1].CreatedAt)
+  }
+}
+
+func TestDeleteMessageLeavesTombstone(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  root := postMessage(t, f, f.member, "Burn them all", "")
+  reply := postMessage(t, f, f.host, "No", root.Id)
+  url := "/api/v1/messages/" + root.Id
+
+  assert.Equal(t, 200, doRequest("DELETE", url, f.member, "").Code)
+  assert.Equal(t, 200, doRequest("DELETE", url, f.member, "").Code)
+  assert.Equal(t, 400, doRequest("PUT", url, f.member, `{"message": {"content": "Burn"}}`).Code)
+
+  // the reply still has its parent, without the content
+  var body struct{ Threads []MessageThread }
+  json.Unmarshal(doRequest("GET", "/api/v1/events/" + f.event.Id + "/messages?thread=tree", f.stranger, "").Body.Bytes(), &body)
+  var tree MessageThread
+  for _, thread := range body.Threads {
+    if thread.Id == root.Id {
+      tree = thread
+    }
+  }
+  assert.NotNil(t, tree.DeletedAt)
+  assert.Equal(t, "", tree.Content)
+  if assert.Len(t, tree.Replies, 1) {
+    assert.Equal(t, reply.Id, tree.Replies[0].Id)
+  }
+
+  // the host can still see what it said
+  revisions, _ := store.ListMessageRevisions(root.Id)
+  if assert.Len(t, revisions, 1) {
+    assert.Equal(t, "Burn them all", revisions[0].Content)
+  }
+}
+
+func TestDeleteEventDeletesMessages(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  message := postMessage(t, f, f.member, "Khaleesi", "")
+  doRequest("PUT", "/api/v1/messages/" + message.Id, f.member, `{"message": {"content": "Mhysa"}}`)
+
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/users/" + f.event.UserId + "/events/" + f.event.Id, f.host, "").Code)
+  _, err := store.FindMessage(message.Id)
+  assert.Equal(t, ErrNotFound, err)
+  revisions, _ := store.ListMessageRevisions(message.Id)
+  assert.Empty(t, revisions)
+}
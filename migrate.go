@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	r "github.com/dancannon/gorethink"
+)
+
+// Migration is a single forward step of the database schema. Migrations are
+// applied in Version order and each Version is applied at most once.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(s *RethinkStore) error
+}
+
+// MigrationRecord is stored in the schema_migrations table for every applied Migration
+type MigrationRecord struct {
+	Version     int       `gorethink:"id"          json:"version"`
+	Description string    `gorethink:"description" json:"description"`
+	AppliedAt   time.Time `gorethink:"applied_at"  json:"applied_at"`
+}
+
+// Migrator is implemented by backends that have a schema to manage
+type Migrator interface {
+	PendingMigrations() ([]Migration, error)
+	Migrate() ([]Migration, error)
+}
+
+const migrationsTable string = "schema_migrations"
+
+// migrations must only ever be appended to
+var migrations = []Migration{
+	{1, "create tables", func(s *RethinkStore) error {
+		for _, table := range []string{"users", "events", "messages", "participants", "sessions"} {
+			if err := s.ensureTable(table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{2, "create secondary indexes", func(s *RethinkStore) error {
+		indexes := []struct{ table, index string }{
+			{"users", "facebook_id"},
+			{"events", "user_id"},
+			{"messages", "user_id"},
+			{"messages", "event_id"},
+			{"participants", "user_id"},
+			{"participants", "event_id"},
+			{"sessions", "user_id"},
+		}
+		for _, i := range indexes {
+			if err := s.ensureIndex(i.table, i.index); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{3, "convert events.location to a geo point", func(s *RethinkStore) error {
+		_, err := s.db().Table("events").Filter(func(e r.Term) r.Term {
+			return e.Field("location").TypeOf().Eq("ARRAY")
+		}).Update(func(e r.Term) interface{} {
+			return map[string]interface{}{
+				"location": r.Point(e.Field("location").Nth(0), e.Field("location").Nth(1)),
+			}
+		}).RunWrite(s.session)
+		if err != nil {
+			return err
+		}
+		return s.ensureIndex("events", "location", r.IndexCreateOpts{Geo: true})
+	}},
+	{4, "create notifications table", func(s *RethinkStore) error {
+		if err := s.ensureTable("notifications"); err != nil {
+			return err
+		}
+		if err := s.ensureIndex("notifications", "user_id"); err != nil {
+			return err
+		}
+		return s.ensureIndex("notifications", "created_at")
+	}},
+	{5, "derive participants.status from the request and response statuses", func(s *RethinkStore) error {
+		_, err := s.db().Table("participants").Filter(func(p r.Term) r.Term {
+			return p.HasFields("status").Not()
+		}).Update(func(p r.Term) interface{} {
+			return map[string]interface{}{
+				"status": r.Branch(
+					p.Field("request_status").Eq("withdrawn"), ParticipantWithdrawn,
+					p.Field("response_status").Eq("accepted"), ParticipantAccepted,
+					p.Field("response_status").Eq("declined"), ParticipantDeclined,
+					ParticipantRequested,
+				),
+				"transitions": []interface{}{},
+			}
+		}).RunWrite(s.session)
+		return err
+	}},
+	{6, "create seats table counting accepted participants", func(s *RethinkStore) error {
+		if err := s.ensureTable("seats"); err != nil {
+			return err
+		}
+		taken := s.db().Table("participants").Filter(r.Row.Field("status").Eq(ParticipantAccepted)).
+			Group("event_id").Count().Ungroup().
+			Map(func(g r.Term) interface{} {
+				return map[string]interface{}{"id": g.Field("group"), "taken": g.Field("reduction")}
+			})
+		_, err := s.db().Table("seats").Insert(taken, r.InsertOpts{Conflict: "replace"}).RunWrite(s.session)
+		return err
+	}},
+	{7, "create invite_links table and users.email index", func(s *RethinkStore) error {
+		if err := s.ensureTable("invite_links"); err != nil {
+			return err
+		}
+		return s.ensureIndex("users", "email")
+	}},
+	{8, "create messages.references index", func(s *RethinkStore) error {
+		return s.ensureIndex("messages", "references")
+	}},
+	{9, "create message_revisions table", func(s *RethinkStore) error {
+		if err := s.ensureTable("message_revisions"); err != nil {
+			return err
+		}
+		if err := s.ensureIndex("message_revisions", "message_id"); err != nil {
+			return err
+		}
+		return s.ensureIndex("message_revisions", "user_id")
+	}},
+	{10, "create mutes and moderation_actions tables", func(s *RethinkStore) error {
+		for _, table := range []string{"mutes", "moderation_actions"} {
+			if err := s.ensureTable(table); err != nil {
+				return err
+			}
+			if err := s.ensureIndex(table, "event_id"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{11, "create compound indexes for cursor pagination", func(s *RethinkStore) error {
+		indexes := []struct {
+			table  string
+			fields []string
+		}{
+			{"users", []string{"created_at"}},
+			{"events", []string{"created_at"}},
+			{"events", []string{"user_id", "created_at"}},
+			{"messages", []string{"user_id", "created_at"}},
+			{"messages", []string{"event_id", "created_at"}},
+			{"messages", []string{"event_id", "references", "created_at"}},
+			{"messages", []string{"references", "created_at"}},
+			{"participants", []string{"user_id", "created_at"}},
+			{"participants", []string{"event_id", "created_at"}},
+			{"participants", []string{"event_id", "status", "updated_at"}},
+			{"mutes", []string{"event_id", "created_at"}},
+			{"moderation_actions", []string{"event_id", "created_at"}},
+		}
+		for _, i := range indexes {
+			if err := s.ensureListIndex(i.table, i.fields...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{12, "create invite_links.event_id and invite_links.user_id indexes", func(s *RethinkStore) error {
+		if err := s.ensureIndex("invite_links", "event_id"); err != nil {
+			return err
+		}
+		return s.ensureIndex("invite_links", "user_id")
+	}},
+	{13, "create mutes.user_id index", func(s *RethinkStore) error {
+		return s.ensureIndex("mutes", "user_id")
+	}},
+}
+
+func (s *RethinkStore) db() r.Term {
+	return r.Db(s.database)
+}
+
+func (s *RethinkStore) ensureDatabase() error {
+	var exists bool
+	if err := s.first(r.DbList().Contains(s.database), &exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	log.Printf("Creating database %s\n", s.database)
+	_, err := r.DbCreate(s.database).RunWrite(s.session)
+	return err
+}
+
+func (s *RethinkStore) ensureTable(table string) error {
+	var exists bool
+	if err := s.first(s.db().TableList().Contains(table), &exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	log.Printf("Creating table %s\n", table)
+	_, err := s.db().TableCreate(table).RunWrite(s.session)
+	return err
+}
+
+func (s *RethinkStore) ensureIndex(table, index string, opts ...r.IndexCreateOpts) error {
+	return s.ensureIndexBy(table, index, s.db().Table(table).IndexCreate(index, opts...))
+}
+
+// ensureListIndex creates the compound index pageRange reads a list through:
+// fields, the last being the time the list is ordered by, then id. It's
+// named after fields. The other fields default to "" so documents written
+// before they existed are still indexed.
+func (s *RethinkStore) ensureListIndex(table string, fields ...string) error {
+	index := strings.Join(fields, "_")
+	create := s.db().Table(table).IndexCreateFunc(index, func(row r.Term) interface{} {
+		key := []interface{}{}
+		for i, field := range fields {
+			if i < len(fields)-1 {
+				key = append(key, row.Field(field).Default(""))
+			} else {
+				key = append(key, row.Field(field))
+			}
+		}
+		return append(key, row.Field("id"))
+	})
+	return s.ensureIndexBy(table, index, create)
+}
+
+// ensureIndexBy runs create unless table already has index, then waits for the index
+func (s *RethinkStore) ensureIndexBy(table, index string, create r.Term) error {
+	var exists bool
+	if err := s.first(s.db().Table(table).IndexList().Contains(index), &exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	log.Printf("Creating index %s on %s\n", index, table)
+	if _, err := create.RunWrite(s.session); err != nil {
+		return err
+	}
+	_, err := s.db().Table(table).IndexWait(index).Run(s.session)
+	return err
+}
+
+// appliedMigrations returns the set of versions recorded in schema_migrations
+func (s *RethinkStore) appliedMigrations() (map[int]bool, error) {
+	applied := make(map[int]bool)
+
+	var exists bool
+	if err := s.first(r.DbList().Contains(s.database), &exists); err != nil || !exists {
+		return applied, err
+	}
+	if err := s.first(s.db().TableList().Contains(migrationsTable), &exists); err != nil || !exists {
+		return applied, err
+	}
+
+	records := []MigrationRecord{}
+	if err := s.all(s.db().Table(migrationsTable), &records); err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		applied[rec.Version] = true
+	}
+	return applied, nil
+}
+
+// PendingMigrations returns the migrations that haven't been applied yet, in order
+func (s *RethinkStore) PendingMigrations() ([]Migration, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration, recording each as it completes.
+// It stops at the first failure so later migrations never run against a
+// half-migrated schema.
+func (s *RethinkStore) Migrate() ([]Migration, error) {
+	if err := s.ensureDatabase(); err != nil {
+		return nil, err
+	}
+	if err := s.ensureTable(migrationsTable); err != nil {
+		return nil, err
+	}
+
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, m := range pending {
+		log.Printf("Applying migration %d: %s\n", m.Version, m.Description)
+		if err := m.Up(s); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+
+		rec := MigrationRecord{Version: m.Version, Description: m.Description, AppliedAt: time.Now()}
+		if _, err := s.db().Table(migrationsTable).Insert(rec).RunWrite(s.session); err != nil {
+			return done, fmt.Errorf("migration %d applied but not recorded: %v", m.Version, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// checkMigrations refuses to start against a backend with an out of date schema
+func checkMigrations(s Store) error {
+	m, ok := s.(Migrator)
+	if !ok {
+		return nil
+	}
+
+	pending, err := m.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migration(s), starting with %d (%s); run `goreson migrate` first",
+			len(pending), pending[0].Version, pending[0].Description)
+	}
+	return nil
+}
+
+// runMigrateCommand implements the `migrate` subcommand. `migrate status`
+// lists pending migrations without applying them.
+func runMigrateCommand(s Store, args []string) error {
+	m, ok := s.(Migrator)
+	if !ok {
+		log.Println("Store has no schema, nothing to migrate")
+		return nil
+	}
+
+	if len(args) > 0 && args[0] == "status" {
+		pending, err := m.PendingMigrations()
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			log.Println("Schema is up to date")
+		}
+		for _, p := range pending {
+			log.Printf("pending: %d %s\n", p.Version, p.Description)
+		}
+		return nil
+	}
+
+	done, err := m.Migrate()
+	for _, d := range done {
+		log.Printf("applied: %d %s\n", d.Version, d.Description)
+	}
+	if err == nil && len(done) == 0 {
+		log.Println("Schema is up to date")
+	}
+	return err
+}
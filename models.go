@@ -16,79 +16,189 @@ type User struct {
 	UpdatedAt  time.Time `gorethink:"updated_at"    json:"updated_at"`
 }
 
+// Event dates are in the venue's Timezone, an IANA name like
+// "America/New_York", or in UTC when it's empty
 type Event struct {
 	Id           string    `gorethink:"id,omitempty"  json:"id"`
 	UserId       string    `gorethink:"user_id"       json:"user_id"`
 	PictureUrl   string    `gorethink:"picture_url"   json:"picture_url"`
-	Lon          string    `gorethink:"-"             json:"lon"`
-	Lat          string    `gorethink:"-"             json:"lat"`
-	Location     []float64 `gorethink:"location"      json:"location"`
+	Location     Location  `gorethink:"location"      json:"location"`
 	Title        string    `gorethink:"title"         json:"title"`
 	Description  string    `gorethink:"description"   json:"description"`
 	PrivacyLevel int       `gorethink:"privacy_level" json:"privacy_level"`
+	Capacity     int       `gorethink:"capacity"      json:"capacity"`
 	StartDate    time.Time `gorethink:"start_date"    json:"start_date"`
 	EndDate      time.Time `gorethink:"end_date"      json:"end_date"`
+	Timezone     string    `gorethink:"timezone"      json:"timezone"`
 	CreatedAt    time.Time `gorethink:"created_at"    json:"created_at"`
 	UpdatedAt    time.Time `gorethink:"updated_at"    json:"updated_at"`
 }
 
+// Location is a [lon, lat] pair. The RethinkDB backend stores it as a geometry point.
+type Location []float64
+
+// NearbyEvent is an Event found by a location search, with its distance from
+// the search point
+type NearbyEvent struct {
+	Event
+	DistanceKm float64 `gorethink:"distance_km"   json:"distance_km"`
+}
+
+// Message is a chat message of an event. References is the id of the
+// message it replies to, empty for messages that start a thread. EditedAt is
+// set once the content is edited, the earlier contents are kept as
+// MessageRevisions. Deleted messages stay as tombstones without content and
+// with DeletedAt set, so their replies keep their place in the thread.
+// HiddenAt and PinnedAt are set by the event's host, see moderation.go.
 type Message struct {
+	Id         string     `gorethink:"id,omitempty"          json:"id"`
+	UserId     string     `gorethink:"user_id"               json:"user_id"`
+	EventId    string     `gorethink:"event_id"              json:"event_id"`
+	References string     `gorethink:"references"            json:"references"`
+	Content    string     `gorethink:"content"               json:"content"`
+	ReplyCount int        `gorethink:"-"                     json:"reply_count"`
+	CreatedAt  time.Time  `gorethink:"created_at"            json:"created_at"`
+	UpdatedAt  time.Time  `gorethink:"updated_at"            json:"updated_at"`
+	EditedAt   *time.Time `gorethink:"edited_at,omitempty"   json:"edited_at"`
+	DeletedAt  *time.Time `gorethink:"deleted_at,omitempty"  json:"deleted_at"`
+	HiddenAt   *time.Time `gorethink:"hidden_at,omitempty"   json:"hidden_at"`
+	PinnedAt   *time.Time `gorethink:"pinned_at,omitempty"   json:"pinned_at"`
+}
+
+// MessageRevision is a content a Message had before it was edited or
+// deleted. CreatedAt is when that content was written, ReplacedAt when it
+// stopped being the message's.
+type MessageRevision struct {
 	Id         string    `gorethink:"id,omitempty"  json:"id"`
+	MessageId  string    `gorethink:"message_id"    json:"message_id"`
 	UserId     string    `gorethink:"user_id"       json:"user_id"`
-	EventId    string    `gorethink:"event_id"      json:"event_id"`
-	References string    `gorethink:"references"    json:"references"`
 	Content    string    `gorethink:"content"       json:"content"`
 	CreatedAt  time.Time `gorethink:"created_at"    json:"created_at"`
-	UpdatedAt  time.Time `gorethink:"updated_at"    json:"updated_at"`
+	ReplacedAt time.Time `gorethink:"replaced_at"   json:"replaced_at"`
+}
+
+// MessageThread is a Message with its replies, and theirs, oldest first
+type MessageThread struct {
+	Message
+	Replies []MessageThread `json:"replies"`
+}
+
+// Mute keeps a user from posting messages in an event. Its Id is derived
+// from both, like a participant's, so a user is muted at most once.
+type Mute struct {
+	Id        string    `gorethink:"id,omitempty"  json:"id"`
+	EventId   string    `gorethink:"event_id"      json:"event_id"`
+	UserId    string    `gorethink:"user_id"       json:"user_id"`
+	ActorId   string    `gorethink:"actor_id"      json:"actor_id"`
+	Reason    string    `gorethink:"reason"        json:"reason"`
+	CreatedAt time.Time `gorethink:"created_at"    json:"created_at"`
+}
+
+// ModerationAction is an entry of an event's moderation log: ActorId did
+// Action to MessageId, written by UserId, or to UserId itself for mutes.
+type ModerationAction struct {
+	Id        string    `gorethink:"id,omitempty"  json:"id"`
+	EventId   string    `gorethink:"event_id"      json:"event_id"`
+	ActorId   string    `gorethink:"actor_id"      json:"actor_id"`
+	Action    string    `gorethink:"action"        json:"action"`
+	MessageId string    `gorethink:"message_id"    json:"message_id"`
+	UserId    string    `gorethink:"user_id"       json:"user_id"`
+	Reason    string    `gorethink:"reason"        json:"reason"`
+	CreatedAt time.Time `gorethink:"created_at"    json:"created_at"`
 }
 
+// MessageChange is a created, updated or deleted Message, as pushed to streams.
+// Deletes carry the tombstone, or the message as it was when it's removed for good.
+type MessageChange struct {
+	Type    string  `json:"type"`
+	Message Message `json:"message"`
+}
+
+const (
+	MessageCreated = "created"
+	MessageUpdated = "updated"
+	MessageDeleted = "deleted"
+)
+
 type Participant struct {
-	Id             string    `gorethink:"id,omitempty"    json:"id"`
-	EventId        string    `gorethink:"event_id"        json:"event_id"`
-	Event          Event     `gorethink:"event"           json:"event"`
-	UserId         string    `gorethink:"user_id"         json:"user_id"`
-	User           User      `gorethink:"user"            json:"user"`
-	RequestStatus  string    `gorethink:"request_status"  json:"request_status"`
-	ResponseStatus string    `gorethink:"response_status" json:"response_status"`
-	CreatedAt      time.Time `gorethink:"created_at"      json:"created_at"`
-	UpdatedAt      time.Time `gorethink:"updated_at"      json:"updated_at"`
+	Id             string                  `gorethink:"id,omitempty"    json:"id"`
+	EventId        string                  `gorethink:"event_id"        json:"event_id"`
+	Event          Event                   `gorethink:"event"           json:"event"`
+	UserId         string                  `gorethink:"user_id"         json:"user_id"`
+	User           User                    `gorethink:"user"            json:"user"`
+	Status         string                  `gorethink:"status"          json:"status"`
+	RequestStatus  string                  `gorethink:"request_status"  json:"request_status"`
+	ResponseStatus string                  `gorethink:"response_status" json:"response_status"`
+	Transitions    []ParticipantTransition `gorethink:"transitions"     json:"transitions"`
+	CreatedAt      time.Time               `gorethink:"created_at"      json:"created_at"`
+	UpdatedAt      time.Time               `gorethink:"updated_at"      json:"updated_at"`
 }
 
+// ParticipantWrite is a participation as stored. Status moves through the
+// state machine in participants.go; RequestStatus and ResponseStatus are
+// derived from it for older clients.
 type ParticipantWrite struct {
-	Id             string    `gorethink:"id,omitempty"    json:"id"`
-	EventId        string    `gorethink:"event_id"        json:"event_id"`
-	UserId         string    `gorethink:"user_id"         json:"user_id"`
-	RequestStatus  string    `gorethink:"request_status"  json:"request_status"`
-	ResponseStatus string    `gorethink:"response_status" json:"response_status"`
-	CreatedAt      time.Time `gorethink:"created_at"      json:"created_at"`
-	UpdatedAt      time.Time `gorethink:"updated_at"      json:"updated_at"`
+	Id             string                  `gorethink:"id,omitempty"    json:"id"`
+	EventId        string                  `gorethink:"event_id"        json:"event_id"`
+	UserId         string                  `gorethink:"user_id"         json:"user_id"`
+	Status         string                  `gorethink:"status"          json:"status"`
+	RequestStatus  string                  `gorethink:"request_status"  json:"request_status"`
+	ResponseStatus string                  `gorethink:"response_status" json:"response_status"`
+	Transitions    []ParticipantTransition `gorethink:"transitions"     json:"transitions"`
+	CreatedAt      time.Time               `gorethink:"created_at"      json:"created_at"`
+	UpdatedAt      time.Time               `gorethink:"updated_at"      json:"updated_at"`
 }
 
-type UserSession struct {
-	Id        string    `gorethink:"id,omitempty"  json:"id"`
+// ParticipantTransition is one step in a participant's history. From is
+// empty for the initial request, ActorId for automatic waitlist promotions.
+type ParticipantTransition struct {
+	From    string    `gorethink:"from"            json:"from"`
+	To      string    `gorethink:"to"              json:"to"`
+	ActorId string    `gorethink:"actor_id"        json:"actor_id"`
+	At      time.Time `gorethink:"at"              json:"at"`
+}
+
+// Notification tells a user about something that happened to them, like a
+// participation request on an event they host. Ids sort in creation order
+// and serve as the resume cursor of the notifications stream.
+type Notification struct {
+	Id          string           `gorethink:"id"              json:"id"`
+	UserId      string           `gorethink:"user_id"         json:"user_id"`
+	Type        string           `gorethink:"type"            json:"type"`
+	EventId     string           `gorethink:"event_id"        json:"event_id"`
+	ActorId     string           `gorethink:"actor_id"        json:"actor_id"`
+	Participant ParticipantWrite `gorethink:"participant"     json:"participant"`
+	CreatedAt   time.Time        `gorethink:"created_at"      json:"created_at"`
+}
+
+// InviteLink lets whoever has its Id (the token) be invited to an event
+// until ExpiresAt. A zero ExpiresAt never expires.
+type InviteLink struct {
+	Id        string    `gorethink:"id,omitempty"  json:"token"`
+	EventId   string    `gorethink:"event_id"      json:"event_id"`
 	UserId    string    `gorethink:"user_id"       json:"user_id"`
+	ExpiresAt time.Time `gorethink:"expires_at"    json:"expires_at"`
 	CreatedAt time.Time `gorethink:"created_at"    json:"created_at"`
-	UpdatedAt time.Time `gorethink:"updated_at"    json:"updated_at"`
+}
+
+type UserSession struct {
+	Id         string    `gorethink:"id,omitempty"  json:"id"`
+	UserId     string    `gorethink:"user_id"       json:"user_id"`
+	DeviceId   string    `gorethink:"device_id"     json:"device_id"`
+	DeviceName string    `gorethink:"device_name"   json:"device_name"`
+	ExpiresAt  time.Time `gorethink:"expires_at"    json:"expires_at"`
+	LastSeenAt time.Time `gorethink:"last_seen_at"  json:"last_seen_at"`
+	Current    bool      `gorethink:"-"             json:"current"`
+	CreatedAt  time.Time `gorethink:"created_at"    json:"created_at"`
+	UpdatedAt  time.Time `gorethink:"updated_at"    json:"updated_at"`
 }
 
 // Helper Structs
 
-type Params struct {
-	User        User             `json:"user"`
-	Event       Event            `json:"event"`
-	Message     Message          `json:"message"`
-	Participant ParticipantWrite `json:"participant"`
-	FacebookId  string           `json:"facebook_id"`
-	Sid         string           `json:"sid"`
-}
-
-type RawParams struct {
-	User        map[string]string `json:"user"`
-	Event       map[string]string `json:"event"`
-	Message     map[string]string `json:"message"`
-	Participant map[string]string `json:"participant"`
-	FacebookId  string            `json:"facebook_id"`
-	Sid         string            `json:"sid"`
+// Device identifies the client a session belongs to
+type Device struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
 }
 
 // var geoJson = {
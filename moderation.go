@@ -0,0 +1,57 @@
+package main
+
+// Message fields the event's host sets, see SetMessageFlag
+const (
+	FlagHidden = "hidden_at"
+	FlagPinned = "pinned_at"
+)
+
+// Moderation actions, recorded in ModerationAction.Action
+const (
+	ModerationDelete = "delete"
+	ModerationHide   = "hide"
+	ModerationUnhide = "unhide"
+	ModerationPin    = "pin"
+	ModerationUnpin  = "unpin"
+	ModerationMute   = "mute"
+	ModerationUnmute = "unmute"
+)
+
+// messageModerations are the actions on a message besides deleting it: the
+// flag each one changes and whether it sets or clears it
+var messageModerations = map[string]struct {
+	flag string
+	set  bool
+}{
+	ModerationHide:   {FlagHidden, true},
+	ModerationUnhide: {FlagHidden, false},
+	ModerationPin:    {FlagPinned, true},
+	ModerationUnpin:  {FlagPinned, false},
+}
+
+// muteId derives a mute's id from its event and user, see participantId
+func muteId(eventId, userId string) string {
+	return participantId(eventId, userId)
+}
+
+// maskHidden empties the content of hidden messages for everyone but the
+// event's host and the message's author
+func maskHidden(messages []Message, event Event, user User) {
+	for i := range messages {
+		maskHiddenMessage(&messages[i], event, user)
+	}
+}
+
+func maskHiddenMessage(m *Message, event Event, user User) {
+	if m.HiddenAt != nil && user.Id != event.UserId && user.Id != m.UserId {
+		m.Content = ""
+	}
+}
+
+// maskHiddenThreads is maskHidden for threads and all their replies
+func maskHiddenThreads(threads []MessageThread, event Event, user User) {
+	for i := range threads {
+		maskHiddenMessage(&threads[i].Message, event, user)
+		maskHiddenThreads(threads[i].Replies, event, user)
+	}
+}
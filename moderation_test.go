@@ -0,0 +1,134 @@
+package main
+
+import (
+  "encoding/json"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestHostHidesAndPinsMessages(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  spam := postMessage(t, f, f.member, "Buy cheap dragons", "")
+  pinned := postMessage(t, f, f.member, "We sail at dawn", "")
+  url := "/api/v1/messages/" + spam.Id + "/moderation"
+
+  // only the host moderates
+  assert.Equal(t, 403, doRequest("POST", url, f.member, `{"moderation": {"action": "hide"}}`).Code)
+  assert.Equal(t, 422, doRequest("POST", url, f.host, `{"moderation": {"action": "burn"}}`).Code)
+
+  var body struct {
+    Message  Message
+    Changed  bool
+    Messages []Message
+  }
+  json.Unmarshal(doRequest("POST", url, f.host, `{"moderation": {"action": "hide", "reason": "spam"}}`).Body.Bytes(), &body)
+  assert.True(t, body.Changed)
+  assert.NotNil(t, body.Message.HiddenAt)
+  json.Unmarshal(doRequest("POST", url, f.host, `{"moderation": {"action": "hide"}}`).Body.Bytes(), &body)
+  assert.False(t, body.Changed)
+  doRequest("POST", "/api/v1/messages/" + pinned.Id + "/moderation", f.host, `{"moderation": {"action": "pin"}}`)
+
+  // hidden content is only shown to the host and the author
+  contents := func(sid string) map[string]string {
+    json.Unmarshal(doRequest("GET", "/api/v1/events/" + f.event.Id + "/messages?per=100", sid, "").Body.Bytes(), &body)
+    c := map[string]string{}
+    for _, m := range body.Messages {
+      c[m.Id] = m.Content
+    }
+    return c
+  }
+  assert.Equal(t, "", contents(f.stranger)[spam.Id])
+  assert.Equal(t, "Buy cheap dragons", contents(f.member)[spam.Id])
+  assert.Equal(t, "Buy cheap dragons", contents(f.host)[spam.Id])
+
+  json.Unmarshal(doRequest("GET", "/api/v1/events/" + f.event.Id + "/pins", f.stranger, "").Body.Bytes(), &body)
+  if assert.Len(t, body.Messages, 1) {
+    assert.Equal(t, pinned.Id, body.Messages[0].Id)
+  }
+
+  // the log is the host's, newest first
+  assert.Equal(t, 403, doRequest("GET", "/api/v1/events/" + f.event.Id + "/moderation", f.member, "").Code)
+  var log struct{ Actions []ModerationAction }
+  json.Unmarshal(doRequest("GET", "/api/v1/events/" + f.event.Id + "/moderation", f.host, "").Body.Bytes(), &log)
+  if assert.Len(t, log.Actions, 2) {
+    assert.Equal(t, ModerationPin, log.Actions[0].Action)
+    assert.Equal(t, ModerationHide, log.Actions[1].Action)
+    assert.Equal(t, "spam", log.Actions[1].Reason)
+    assert.Equal(t, spam.UserId, log.Actions[1].UserId)
+  }
+}
+
+func TestHostDeletesMessages(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  message := postMessage(t, f, f.member, "Dracarys", "")
+
+  assert.Equal(t, 403, doRequest("DELETE", "/api/v1/messages/" + message.Id, f.stranger, "").Code)
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/messages/" + message.Id, f.host, "").Code)
+  deleted, _ := store.FindMessage(message.Id)
+  assert.NotNil(t, deleted.DeletedAt)
+
+  actions, _ := store.ListEventModeration(f.event.Id, Page{Number: 1, Per: 20})
+  if assert.Len(t, actions, 1) {
+    assert.Equal(t, ModerationDelete, actions[0].Action)
+    assert.Equal(t, message.Id, actions[0].MessageId)
+  }
+}
+
+func TestMutedUsersCantPost(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  guest, guest_sid := newTestUser(t, "Tyrion")
+  message := postMessage(t, f, guest_sid, "A Lannister always pays his debts", "")
+  url := "/api/v1/events/" + f.event.Id + "/mutes"
+
+  assert.Equal(t, 403, doRequest("POST", url, f.member, `{"mute": {"user_id": "` + guest.Id + `"}}`).Code)
+  assert.Equal(t, 422, doRequest("POST", url, f.host, `{"mute": {}}`).Code)
+
+  var body struct {
+    Mute    Mute
+    Created bool
+  }
+  json.Unmarshal(doRequest("POST", url, f.host, `{"mute": {"user_id": "` + guest.Id + `", "reason": "drunk"}}`).Body.Bytes(), &body)
+  assert.True(t, body.Created)
+  json.Unmarshal(doRequest("POST", url, f.host, `{"mute": {"user_id": "` + guest.Id + `"}}`).Body.Bytes(), &body)
+  assert.False(t, body.Created)
+  assert.Equal(t, "drunk", body.Mute.Reason)
+
+  assert.Equal(t, 403, doRequest("POST", "/api/v1/events/" + f.event.Id + "/messages", guest_sid, `{"message": {"content": "Wine"}}`).Code)
+  assert.Equal(t, 403, doRequest("PUT", "/api/v1/messages/" + message.Id, guest_sid, `{"message": {"content": "Wine"}}`).Code)
+  // they can still read
+  assert.Equal(t, 200, doRequest("GET", "/api/v1/events/" + f.event.Id + "/messages", guest_sid, "").Code)
+
+  assert.Equal(t, 200, doRequest("DELETE", url + "/" + guest.Id, f.host, "").Code)
+  assert.Equal(t, 404, doRequest("DELETE", url + "/" + guest.Id, f.host, "").Code)
+  postMessage(t, f, guest_sid, "Wine", "")
+
+  actions, _ := store.ListEventModeration(f.event.Id, Page{Number: 1, Per: 20})
+  if assert.Len(t, actions, 2) {
+    assert.Equal(t, ModerationUnmute, actions[0].Action)
+    assert.Equal(t, ModerationMute, actions[1].Action)
+  }
+}
+
+func TestDeletesRemoveModeration(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  guest, guest_sid := newTestUser(t, "Tyrion")
+  url := "/api/v1/events/" + f.event.Id + "/mutes"
+  assert.Equal(t, 200, doRequest("POST", url, f.host, `{"mute": {"user_id": "` + guest.Id + `"}}`).Code)
+
+  // a deleted user's mutes go
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/users/" + guest.Id, guest_sid, "").Code)
+  _, err := store.FindMute(f.event.Id, guest.Id)
+  assert.Equal(t, ErrNotFound, err)
+
+  // a deleted event's mutes and moderation log go
+  sansa, _ := newTestUser(t, "Sansa")
+  assert.Equal(t, 200, doRequest("POST", url, f.host, `{"mute": {"user_id": "` + sansa.Id + `"}}`).Code)
+  log, _ := store.ListEventModeration(f.event.Id, Page{Number: 1, Per: 10})
+  assert.NotEmpty(t, log)
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/users/" + f.event.UserId + "/events/" + f.event.Id, f.host, "").Code)
+  _, err = store.FindMute(f.event.Id, sansa.Id)
+  assert.Equal(t, ErrNotFound, err)
+  log, _ = store.ListEventModeration(f.event.Id, Page{Number: 1, Per: 10})
+  assert.Empty(t, log)
+}
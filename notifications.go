@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Notification types, one per participant state the participant moved to
+const (
+	NotifyParticipantRequested  = "participant." + ParticipantRequested
+	NotifyParticipantInvited    = "participant." + ParticipantInvited
+	NotifyParticipantAccepted   = "participant." + ParticipantAccepted
+	NotifyParticipantDeclined   = "participant." + ParticipantDeclined
+	NotifyParticipantWaitlisted = "participant." + ParticipantWaitlisted
+	NotifyParticipantWithdrawn  = "participant." + ParticipantWithdrawn
+	NotifyParticipantRemoved    = "participant." + ParticipantRemoved
+)
+
+// NotifyInviteLinkRedeemed tells a host that someone used their invite link,
+// the participant's transition names the host as the one who invited
+const NotifyInviteLinkRedeemed = "invite_link.redeemed"
+
+// notificationReplayBatch is how many missed notifications a resuming
+// stream reads at a time, it keeps reading until it has sent them all
+const notificationReplayBatch = 500
+
+// notificationPruneInterval is how often expired notifications are deleted
+const notificationPruneInterval = time.Hour
+
+// newNotificationId returns an id that sorts after the ids of notifications
+// created earlier: the creation time in fixed width hex, then a random
+// suffix so ids created in the same nanosecond don't collide.
+func newNotificationId(t time.Time) string {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return fmt.Sprintf("%016x-%s", t.UnixNano(), newId()[:8])
+}
+
+// notifyParticipant tells the event's host and the participating user that
+// actor moved the participant to its current state. The actor isn't told
+// about their own change. Failures are logged, the transition itself
+// already happened.
+func notifyParticipant(p ParticipantWrite, event Event, actor User) {
+	kind := "participant." + p.Status
+	now := time.Now()
+	for _, userId := range []string{event.UserId, p.UserId} {
+		if userId == actor.Id {
+			continue
+		}
+		_, err := store.CreateNotification(Notification{
+			UserId:      userId,
+			Type:        kind,
+			EventId:     event.Id,
+			ActorId:     actor.Id,
+			Participant: p,
+			CreatedAt:   now,
+		})
+		if err != nil {
+			errorf("Couldn't notify User#%s of %s: %v\n", userId, kind, err)
+		}
+	}
+}
+
+// pruneNotifications deletes notifications older than retention every
+// notificationPruneInterval, forever. A retention of 0 keeps them all.
+func pruneNotifications(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	for range time.Tick(notificationPruneInterval) {
+		if err := store.DeleteNotificationsBefore(time.Now().Add(-retention)); err != nil {
+			errorf("Couldn't prune notifications: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestParticipantTransitionsNotify(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  guest, guest_sid := newTestUser(t, "Robert")
+  event, _ := store.CreateEvent(Event{UserId: host.Id, Title: "Feast", CreatedAt: time.Now()})
+
+  res := doRequest("POST", "/api/v1/events/" + event.Id + "/participants", guest_sid, "")
+  assert.Equal(t, 200, res.Code)
+  var body struct{ Participant ParticipantWrite }
+  json.Unmarshal(res.Body.Bytes(), &body)
+
+  // the host hears about the request, the requester doesn't hear about their own
+  notifications, _ := store.ListUserNotifications(host.Id, "", 0)
+  assert.Equal(t, 1, len(notifications))
+  assert.Equal(t, NotifyParticipantRequested, notifications[0].Type)
+  assert.Equal(t, guest.Id, notifications[0].ActorId)
+  notifications, _ = store.ListUserNotifications(guest.Id, "", 0)
+  assert.Equal(t, 0, len(notifications))
+
+  res = doRequest("PUT", "/api/v1/participants/" + body.Participant.Id, host_sid, `{"participant": {"response_status": "accepted"}}`)
+  assert.Equal(t, 200, res.Code)
+  notifications, _ = store.ListUserNotifications(guest.Id, "", 0)
+  assert.Equal(t, 1, len(notifications))
+  assert.Equal(t, NotifyParticipantAccepted, notifications[0].Type)
+  assert.Equal(t, event.Id, notifications[0].EventId)
+
+  // nothing changed, nothing to tell
+  doRequest("PUT", "/api/v1/participants/" + body.Participant.Id, host_sid, `{"participant": {"response_status": "accepted"}}`)
+  notifications, _ = store.ListUserNotifications(guest.Id, "", 0)
+  assert.Equal(t, 1, len(notifications))
+}
+
+func TestStreamNotificationsResumes(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  event, _ := store.CreateEvent(Event{UserId: host.Id, Title: "Feast", CreatedAt: time.Now()})
+  request := func(first_name string) {
+    _, sid := newTestUser(t, first_name)
+    assert.Equal(t, 200, doRequest("POST", "/api/v1/events/" + event.Id + "/participants", sid, "").Code)
+  }
+  request("Robert")
+  request("Jaime")
+  seen, _ := store.ListUserNotifications(host.Id, "", 1)
+
+  server := httptest.NewServer(initRouting())
+  defer server.Close()
+
+  req, _ := http.NewRequest("GET", server.URL + "/api/v1/notifications/stream", nil)
+  req.Header.Set("Authorization", "Bearer " + host_sid)
+  req.Header.Set("Last-Event-ID", seen[0].Id)
+  resp, err := http.DefaultClient.Do(req)
+  assert.Nil(t, err)
+  defer resp.Body.Close()
+  assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+  // Jaime's request was missed and is replayed, Cersei's arrives live
+  request("Cersei")
+  lines := bufio.NewReader(resp.Body)
+  var actors []string
+  for len(actors) < 2 {
+    id, _ := lines.ReadString('\n')
+    event_line, _ := lines.ReadString('\n')
+    data, _ := lines.ReadString('\n')
+    lines.ReadString('\n')
+    assert.True(t, strings.HasPrefix(id, "id: "))
+    assert.Equal(t, "event: " + NotifyParticipantRequested + "\n", event_line)
+
+    n := Notification{}
+    assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &n))
+    assert.True(t, n.Id > seen[0].Id)
+    user, _ := store.FindUser(n.ActorId)
+    actors = append(actors, user.FirstName)
+  }
+  assert.Equal(t, []string{"Jaime", "Cersei"}, actors)
+}
+
+func TestStreamNotificationsResumesAcrossBatches(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  t0 := time.Now()
+  missed := 2 * notificationReplayBatch + 3
+  for i := 0; i <= missed; i++ {
+    store.CreateNotification(Notification{UserId: host.Id, Type: NotifyParticipantRequested, CreatedAt: t0.Add(time.Duration(i))})
+  }
+  all, _ := store.ListUserNotifications(host.Id, "", 0)
+
+  server := httptest.NewServer(initRouting())
+  defer server.Close()
+
+  req, _ := http.NewRequest("GET", server.URL + "/api/v1/notifications/stream", nil)
+  req.Header.Set("Authorization", "Bearer " + host_sid)
+  req.Header.Set("Last-Event-ID", all[0].Id)
+  resp, err := http.DefaultClient.Do(req)
+  assert.Nil(t, err)
+  defer resp.Body.Close()
+
+  // every missed notification, oldest first, then a live one
+  store.CreateNotification(Notification{UserId: host.Id, Type: NotifyParticipantRequested})
+  lines := bufio.NewScanner(resp.Body)
+  ids := []string{}
+  for len(ids) < missed + 1 && lines.Scan() {
+    if line := lines.Text(); strings.HasPrefix(line, "id: ") {
+      ids = append(ids, strings.TrimPrefix(line, "id: "))
+    }
+  }
+  if assert.Equal(t, missed + 1, len(ids)) {
+    assert.Equal(t, all[1].Id, ids[0])
+    assert.Equal(t, all[missed].Id, ids[missed - 1])
+    assert.True(t, ids[missed] > all[missed].Id)
+  }
+}
+
+func TestNotificationIdsSortByTime(t *testing.T) {
+  t0 := time.Now()
+  a := newNotificationId(t0)
+  b := newNotificationId(t0.Add(time.Nanosecond))
+  assert.True(t, a < b)
+  assert.NotEqual(t, a, newNotificationId(t0))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page selects part of a list. With After or Before set it's the Per items
+// right after or before that cursor, which clients get from the next and prev
+// links of an earlier page. Otherwise it's page Number of Per items, the
+// offset pagination older clients use.
+type Page struct {
+	Number int
+	Per    int
+	After  *Cursor
+	Before *Cursor
+}
+
+// Cursor is the position of an item in a list ordered by a time, mostly
+// created_at, then id
+type Cursor struct {
+	At time.Time
+	Id string
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// compareCursors orders a and b the way lists are ordered, oldest first
+func compareCursors(a, b Cursor) int {
+	switch {
+	case a.At.Before(b.At):
+		return -1
+	case a.At.After(b.At):
+		return 1
+	}
+	return strings.Compare(a.Id, b.Id)
+}
+
+// String encodes c as an opaque, URL safe token
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.At.UnixNano(), 10) + "," + c.Id))
+}
+
+// parseCursor decodes a token made by Cursor.String
+func parseCursor(token string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	parts := strings.SplitN(string(b), ",", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Cursor{}, errInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	return Cursor{At: time.Unix(0, nanos).UTC(), Id: parts[1]}, nil
+}
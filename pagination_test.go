@@ -0,0 +1,75 @@
+package main
+
+import (
+  "encoding/json"
+  "strings"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+type messagePage struct {
+  Messages []Message
+  Page     string
+  Next     *string
+  Prev     *string
+}
+
+func getMessagePage(t *testing.T, url, sid string) messagePage {
+  var body messagePage
+  res := doRequest("GET", url, sid, "")
+  assert.Equal(t, 200, res.Code, res.Body.String())
+  json.Unmarshal(res.Body.Bytes(), &body)
+  return body
+}
+
+func TestCursorPagination(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  for _, content := range []string{"Fire", "Blood", "Ships", "Wine"} {
+    postMessage(t, f, f.member, content, "")
+  }
+
+  contents := func(p messagePage) []string {
+    c := []string{}
+    for _, m := range p.Messages {
+      c = append(c, m.Content)
+    }
+    return c
+  }
+
+  // the legacy sid param is left out of the links
+  first := getMessagePage(t, "/api/v1/events/" + f.event.Id + "/messages?per=2&sid=" + f.member, "")
+  assert.Equal(t, []string{"Dracarys", "Fire"}, contents(first))
+  assert.Nil(t, first.Prev)
+  if !assert.NotNil(t, first.Next) {
+    return
+  }
+  assert.False(t, strings.Contains(*first.Next, f.member))
+  assert.True(t, strings.Contains(*first.Next, "per=2"))
+
+  second := getMessagePage(t, *first.Next, f.member)
+  assert.Equal(t, []string{"Blood", "Ships"}, contents(second))
+  last := getMessagePage(t, *second.Next, f.member)
+  assert.Equal(t, []string{"Wine"}, contents(last))
+  assert.Nil(t, last.Next)
+
+  // and back again
+  if assert.NotNil(t, last.Prev) {
+    back := getMessagePage(t, *last.Prev, f.member)
+    assert.Equal(t, []string{"Blood", "Ships"}, contents(back))
+    back = getMessagePage(t, *back.Prev, f.member)
+    assert.Equal(t, []string{"Dracarys", "Fire"}, contents(back))
+    assert.NotNil(t, back.Prev)
+    assert.Equal(t, 0, len(getMessagePage(t, *back.Prev, f.member).Messages))
+  }
+
+  // page and per still work for older clients
+  old := getMessagePage(t, "/api/v1/events/" + f.event.Id + "/messages?page=2&per=2", f.member)
+  assert.Equal(t, "2", old.Page)
+  assert.Equal(t, []string{"Blood", "Ships"}, contents(old))
+  assert.NotNil(t, old.Prev)
+
+  url := "/api/v1/events/" + f.event.Id + "/messages?after="
+  assert.Equal(t, 400, doRequest("GET", url + "nope", f.member, "").Code)
+  assert.Equal(t, 400, doRequest("GET", url + Cursor{}.String() + "&before=" + Cursor{}.String(), f.member, "").Code)
+}
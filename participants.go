@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Participant states, stored in Participant.Status
+const (
+	ParticipantRequested  = "requested"
+	ParticipantInvited    = "invited"
+	ParticipantAccepted   = "accepted"
+	ParticipantDeclined   = "declined"
+	ParticipantWaitlisted = "waitlisted"
+	ParticipantWithdrawn  = "withdrawn"
+	ParticipantRemoved    = "removed"
+)
+
+// participantActor is who is making a participant transition
+type participantActor int
+
+const (
+	actorRequester participantActor = iota // the participating user, requester or invitee
+	actorHost                              // the event's host
+)
+
+func (a participantActor) String() string {
+	if a == actorHost {
+		return "host"
+	}
+	return "requester"
+}
+
+// participantTransitions is the participant state machine: for every state,
+// the states it may move to and who may move it there
+var participantTransitions = map[string]map[string]participantActor{
+	ParticipantRequested: {
+		ParticipantAccepted:   actorHost,
+		ParticipantDeclined:   actorHost,
+		ParticipantWaitlisted: actorHost,
+		ParticipantWithdrawn:  actorRequester,
+	},
+	ParticipantInvited: {
+		ParticipantAccepted: actorRequester,
+		ParticipantDeclined: actorRequester,
+		ParticipantRemoved:  actorHost,
+	},
+	ParticipantWaitlisted: {
+		ParticipantAccepted:  actorHost,
+		ParticipantDeclined:  actorHost,
+		ParticipantWithdrawn: actorRequester,
+	},
+	ParticipantAccepted: {
+		ParticipantRemoved:   actorHost,
+		ParticipantWithdrawn: actorRequester,
+	},
+	ParticipantDeclined: {
+		ParticipantAccepted: actorHost,
+	},
+	ParticipantWithdrawn: {
+		ParticipantRequested: actorRequester,
+		ParticipantInvited:   actorHost,
+	},
+	ParticipantRemoved: {},
+}
+
+// legacyParticipantStatus is the request_status / response_status pair
+// written for each state, for clients that predate Status
+var legacyParticipantStatus = map[string][2]string{
+	ParticipantRequested:  {"requested", "pending"},
+	ParticipantInvited:    {"invited", "pending"},
+	ParticipantAccepted:   {"requested", "accepted"},
+	ParticipantDeclined:   {"requested", "declined"},
+	ParticipantWaitlisted: {"requested", "waitlisted"},
+	ParticipantWithdrawn:  {"withdrawn", "pending"},
+	ParticipantRemoved:    {"requested", "removed"},
+}
+
+func validParticipantStatus(status string) bool {
+	_, ok := participantTransitions[status]
+	return ok
+}
+
+// participantStatuses lists every status, sorted
+func participantStatuses() []string {
+	statuses := []string{}
+	for status := range participantTransitions {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	return statuses
+}
+
+// TransitionError is a refused participant transition
+type TransitionError struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Allowed []string `json:"allowed"` // the states actor may move the participant to instead
+}
+
+func (e *TransitionError) Error() string {
+	return e.Message
+}
+
+// checkParticipantTransition returns a *TransitionError unless actor may
+// move a participant from one state to another
+func checkParticipantTransition(from, to string, actor participantActor) error {
+	allowed := []string{}
+	for state, who := range participantTransitions[from] {
+		if who == actor {
+			allowed = append(allowed, state)
+		}
+	}
+	sort.Strings(allowed)
+
+	who, ok := participantTransitions[from][to]
+	switch {
+	case !ok:
+		return &TransitionError{"illegal_transition", fmt.Sprintf("A participant can't go from %s to %s", from, to), from, to, allowed}
+	case who != actor:
+		return &TransitionError{"transition_not_permitted", fmt.Sprintf("Only the %s can move a participant from %s to %s", who, from, to), from, to, allowed}
+	}
+	return nil
+}
+
+// applyParticipantTransition moves p to t.To, keeping the legacy status
+// fields in step and recording t in its history
+func applyParticipantTransition(p *ParticipantWrite, t ParticipantTransition) {
+	legacy := legacyParticipantStatus[t.To]
+	p.Status = t.To
+	p.RequestStatus, p.ResponseStatus = legacy[0], legacy[1]
+	p.Transitions = append(p.Transitions, t)
+	p.UpdatedAt = t.At
+}
+
+// participantId derives a participant's id from its event and user, see
+// nameId. A user can then only ever have one participation per event.
+func participantId(eventId, userId string) string {
+	return nameId(eventId + "/" + userId)
+}
+
+// newParticipant is a fresh participation of user in event, requested by
+// the user or invited by the host (the actor)
+func newParticipant(event Event, user User, status string, actor User, t time.Time) ParticipantWrite {
+	p := ParticipantWrite{
+		Id:          participantId(event.Id, user.Id),
+		UserId:      user.Id,
+		EventId:     event.Id,
+		CreatedAt:   t,
+		Transitions: []ParticipantTransition{},
+	}
+	applyParticipantTransition(&p, ParticipantTransition{To: status, ActorId: actor.Id, At: t})
+	return p
+}
+
+// requestedParticipantStatus works out the state an update asks for. status
+// is preferred, the legacy request_status / response_status fields are
+// understood when they differ from the participant's current values. ""
+// means no change was asked for.
+func requestedParticipantStatus(p ParticipantWrite, params ParticipantRequest) string {
+	if params.Status != nil {
+		return *params.Status
+	}
+	if response := params.ResponseStatus; response != nil && *response != p.ResponseStatus {
+		if *response == "pending" {
+			return ParticipantRequested
+		}
+		return *response
+	}
+	if request := params.RequestStatus; request != nil && *request != p.RequestStatus {
+		return *request
+	}
+	return ""
+}
+
+// moveParticipant makes transition t of p, a participant of event, keeping
+// the event's seats in step. Accepting takes a seat; when none is left a
+// request or invitation is waitlisted instead and anything else is refused
+// with an event_full *TransitionError. Leaving accepted hands the seat on to
+// the waitlist.
+func moveParticipant(p ParticipantWrite, event Event, t ParticipantTransition) (ParticipantWrite, error) {
+	reserved := false
+	if t.To == ParticipantAccepted {
+		ok, err := store.ReserveSeat(event.Id, event.Capacity)
+		if err != nil {
+			return p, err
+		}
+		switch {
+		case ok:
+			reserved = true
+		case t.From == ParticipantRequested || t.From == ParticipantInvited:
+			t.To = ParticipantWaitlisted
+		default:
+			return p, &TransitionError{"event_full", fmt.Sprintf("All %d seats are taken", event.Capacity), t.From, t.To, []string{}}
+		}
+	}
+
+	moved, err := store.TransitionParticipant(p.Id, t)
+	if err != nil {
+		if reserved {
+			releaseSeat(event.Id)
+		}
+		return moved, err
+	}
+	if t.From == ParticipantAccepted {
+		releaseSeat(event.Id)
+		promoteWaitlist(event)
+	}
+	return moved, nil
+}
+
+// deleteUserParticipants deletes every participation of userId, for when the
+// user is deleted. Each is withdrawn first where the state machine allows,
+// through moveParticipant like any other withdrawal, so a seat they held
+// goes to the waitlist.
+func deleteUserParticipants(userId string) error {
+	for {
+		participations, err := store.ListUserParticipants(userId, Page{Number: 1, Per: 100})
+		if err != nil {
+			return err
+		}
+		if len(participations) == 0 {
+			return nil
+		}
+		for _, p := range participations {
+			if err := deleteParticipation(p.Id, p.Event, userId); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func deleteParticipation(id string, event Event, userId string) error {
+	p, err := store.FindParticipant(id)
+	for err == nil && p.Status != ParticipantWithdrawn && checkParticipantTransition(p.Status, ParticipantWithdrawn, actorRequester) == nil {
+		_, err = moveParticipant(p, event, ParticipantTransition{
+			From:    p.Status,
+			To:      ParticipantWithdrawn,
+			ActorId: userId,
+			At:      time.Now(),
+		})
+		if err == ErrConflict {
+			// Moved in the meantime, look again
+			p, err = store.FindParticipant(id)
+			continue
+		}
+		break
+	}
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	return store.DeleteParticipant(id)
+}
+
+func releaseSeat(eventId string) {
+	if err := store.ReleaseSeat(eventId); err != nil {
+		errorf("Couldn't release a seat of Event#%s: %v\n", eventId, err)
+	}
+}
+
+// promoteWaitlist accepts waitlisted participants of event, longest waiting
+// first, for as long as there are free seats. Promotions are recorded with
+// an empty ActorId.
+func promoteWaitlist(event Event) {
+	for {
+		waitlist, err := store.ListEventWaitlist(event.Id, Page{Number: 1, Per: 1})
+		if err != nil {
+			errorf("Couldn't read the waitlist of Event#%s: %v\n", event.Id, err)
+			return
+		}
+		if len(waitlist) == 0 {
+			return
+		}
+		ok, err := store.ReserveSeat(event.Id, event.Capacity)
+		if err != nil {
+			errorf("Couldn't reserve a seat of Event#%s: %v\n", event.Id, err)
+			return
+		}
+		if !ok {
+			return
+		}
+
+		promoted, err := store.TransitionParticipant(waitlist[0].Id, ParticipantTransition{
+			From: ParticipantWaitlisted,
+			To:   ParticipantAccepted,
+			At:   time.Now(),
+		})
+		if err != nil {
+			releaseSeat(event.Id)
+			// They left the waitlist in the meantime, try the next one
+			if err == ErrConflict || err == ErrNotFound {
+				continue
+			}
+			errorf("Couldn't promote Participant#%s: %v\n", waitlist[0].Id, err)
+			return
+		}
+		notifyParticipant(promoted, event, User{})
+	}
+}
+
+// sendTransitionError answers a refused transition with a 409 the client can
+// act on, the transition goes in the error's details
+func sendTransitionError(err *TransitionError, w http.ResponseWriter) {
+	sendError(w, http.StatusConflict, err.Code, err.Message, map[string]interface{}{
+		"from":    err.From,
+		"to":      err.To,
+		"allowed": err.Allowed,
+	})
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+  "encoding/json"
+  "sync"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestCheckParticipantTransition(t *testing.T) {
+  cases := []struct {
+    from, to string
+    actor    participantActor
+    code     string
+  }{
+    {ParticipantRequested, ParticipantAccepted, actorHost, ""},
+    {ParticipantRequested, ParticipantAccepted, actorRequester, "transition_not_permitted"},
+    {ParticipantRequested, ParticipantWithdrawn, actorRequester, ""},
+    {ParticipantWaitlisted, ParticipantAccepted, actorHost, ""},
+    {ParticipantAccepted, ParticipantRemoved, actorHost, ""},
+    {ParticipantAccepted, ParticipantRequested, actorRequester, "illegal_transition"},
+    {ParticipantDeclined, ParticipantWithdrawn, actorRequester, "illegal_transition"},
+    {ParticipantWithdrawn, ParticipantRequested, actorRequester, ""},
+    {ParticipantRemoved, ParticipantRequested, actorRequester, "illegal_transition"},
+    {ParticipantInvited, ParticipantDeclined, actorRequester, ""},
+    {ParticipantInvited, ParticipantAccepted, actorHost, "transition_not_permitted"},
+    {ParticipantWithdrawn, ParticipantInvited, actorHost, ""},
+  }
+  for _, c := range cases {
+    err := checkParticipantTransition(c.from, c.to, c.actor)
+    if c.code == "" {
+      assert.Nil(t, err, "%s -> %s by %s", c.from, c.to, c.actor)
+      continue
+    }
+    if assert.NotNil(t, err, "%s -> %s by %s", c.from, c.to, c.actor) {
+      assert.Equal(t, c.code, err.(*TransitionError).Code)
+    }
+  }
+
+  err := checkParticipantTransition(ParticipantRequested, ParticipantRemoved, actorHost).(*TransitionError)
+  assert.Equal(t, []string{ParticipantAccepted, ParticipantDeclined, ParticipantWaitlisted}, err.Allowed)
+}
+
+func TestUpdateParticipantStatus(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  guest, guest_sid := newTestUser(t, "Robert")
+  event, _ := store.CreateEvent(Event{UserId: host.Id, Title: "Feast", CreatedAt: time.Now()})
+
+  var body struct {
+    Participant ParticipantWrite
+    Changed     bool
+    Error       struct {
+      Code    string
+      Details struct{ Allowed []string }
+    }
+  }
+  res := doRequest("POST", "/api/v1/events/" + event.Id + "/participants", guest_sid, "")
+  json.Unmarshal(res.Body.Bytes(), &body)
+  url := "/api/v1/participants/" + body.Participant.Id
+  assert.Equal(t, ParticipantRequested, body.Participant.Status)
+
+  // requesters can't accept themselves
+  res = doRequest("PUT", url, guest_sid, `{"participant": {"status": "accepted"}}`)
+  assert.Equal(t, 409, res.Code)
+  json.Unmarshal(res.Body.Bytes(), &body)
+  assert.Equal(t, "transition_not_permitted", body.Error.Code)
+  assert.Equal(t, []string{ParticipantWithdrawn}, body.Error.Details.Allowed)
+
+  res = doRequest("PUT", url, host_sid, `{"participant": {"status": "nonsense"}}`)
+  assert.Equal(t, 422, res.Code)
+
+  // legacy fields still work
+  res = doRequest("PUT", url, host_sid, `{"participant": {"response_status": "declined"}}`)
+  assert.Equal(t, 200, res.Code)
+  json.Unmarshal(res.Body.Bytes(), &body)
+  assert.True(t, body.Changed)
+  assert.Equal(t, ParticipantDeclined, body.Participant.Status)
+  assert.Equal(t, "declined", body.Participant.ResponseStatus)
+
+  res = doRequest("PUT", url, guest_sid, `{"participant": {"status": "withdrawn"}}`)
+  assert.Equal(t, 409, res.Code)
+
+  res = doRequest("PUT", url, host_sid, `{"participant": {"status": "accepted"}}`)
+  assert.Equal(t, 200, res.Code)
+  // asking again is a no-op
+  res = doRequest("PUT", url, host_sid, `{"participant": {"status": "accepted"}}`)
+  json.Unmarshal(res.Body.Bytes(), &body)
+  assert.False(t, body.Changed)
+
+  p, _ := store.FindParticipant(body.Participant.Id)
+  assert.Equal(t, 3, len(p.Transitions))
+  last := p.Transitions[2]
+  assert.Equal(t, ParticipantDeclined, last.From)
+  assert.Equal(t, ParticipantAccepted, last.To)
+  assert.Equal(t, host.Id, last.ActorId)
+  assert.Equal(t, guest.Id, p.Transitions[0].ActorId)
+}
+
+func TestMemoryStoreTransitionParticipantConflict(t *testing.T) {
+  s := NewMemoryStore()
+  p, _ := s.CreateParticipant(ParticipantWrite{Status: ParticipantRequested})
+
+  _, err := s.TransitionParticipant(p.Id, ParticipantTransition{From: ParticipantRequested, To: ParticipantAccepted})
+  assert.Nil(t, err)
+  // a second writer that read the old state loses
+  _, err = s.TransitionParticipant(p.Id, ParticipantTransition{From: ParticipantRequested, To: ParticipantDeclined})
+  assert.Equal(t, ErrConflict, err)
+  _, err = s.TransitionParticipant("missing", ParticipantTransition{})
+  assert.Equal(t, ErrNotFound, err)
+}
+
+func TestJoinEventIsIdempotent(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  _, guest_sid := newTestUser(t, "Robert")
+  event, _ := store.CreateEvent(Event{UserId: host.Id, Title: "Feast", CreatedAt: time.Now()})
+  url := "/api/v1/events/" + event.Id + "/participants"
+
+  res := doRequest("POST", url, host_sid, "")
+  assert.Equal(t, 403, res.Code)
+
+  // racing requests end up with one participation
+  var wg sync.WaitGroup
+  ids := make([]string, 10)
+  for i := range ids {
+    wg.Add(1)
+    go func(i int) {
+      defer wg.Done()
+      var body struct{ Participant ParticipantWrite }
+      json.Unmarshal(doRequest("POST", url, guest_sid, "").Body.Bytes(), &body)
+      ids[i] = body.Participant.Id
+    }(i)
+  }
+  wg.Wait()
+  for _, id := range ids {
+    assert.Equal(t, ids[0], id)
+  }
+  participants, _ := store.ListEventParticipants(event.Id, Page{Number: 1, Per: 20})
+  assert.Equal(t, 1, len(participants))
+
+  var body struct {
+    Participant ParticipantWrite
+    Created     bool
+  }
+  json.Unmarshal(doRequest("POST", url, guest_sid, "").Body.Bytes(), &body)
+  assert.False(t, body.Created)
+  assert.Equal(t, ids[0], body.Participant.Id)
+
+  // a withdrawn request is made again
+  doRequest("PUT", "/api/v1/participants/" + ids[0], guest_sid, `{"participant": {"status": "withdrawn"}}`)
+  json.Unmarshal(doRequest("POST", url, guest_sid, "").Body.Bytes(), &body)
+  assert.False(t, body.Created)
+  assert.Equal(t, ParticipantRequested, body.Participant.Status)
+  assert.Equal(t, 3, len(body.Participant.Transitions))
+}
+
+func TestCapacityAndWaitlist(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  event, _ := store.CreateEvent(Event{UserId: host.Id, Title: "Feast", Capacity: 1, CreatedAt: time.Now()})
+
+  type guest struct{ sid, participant string }
+  join := func(first_name string) guest {
+    _, sid := newTestUser(t, first_name)
+    var body struct{ Participant ParticipantWrite }
+    json.Unmarshal(doRequest("POST", "/api/v1/events/" + event.Id + "/participants", sid, "").Body.Bytes(), &body)
+    return guest{sid, body.Participant.Id}
+  }
+  status := func(g guest) string {
+    p, _ := store.FindParticipant(g.participant)
+    return p.Status
+  }
+  accept := func(g guest) int {
+    return doRequest("PUT", "/api/v1/participants/" + g.participant, host_sid, `{"participant": {"status": "accepted"}}`).Code
+  }
+  robert, jaime, cersei := join("Robert"), join("Jaime"), join("Cersei")
+
+  assert.Equal(t, 200, accept(robert))
+  assert.Equal(t, ParticipantAccepted, status(robert))
+  // full: requests are waitlisted, the waitlisted can't be accepted
+  assert.Equal(t, 200, accept(jaime))
+  assert.Equal(t, ParticipantWaitlisted, status(jaime))
+  assert.Equal(t, 200, accept(cersei))
+  assert.Equal(t, 409, accept(jaime))
+
+  var body struct{ Participants []Participant }
+  json.Unmarshal(doRequest("GET", "/api/v1/events/" + event.Id + "/waitlist", host_sid, "").Body.Bytes(), &body)
+  if assert.Equal(t, 2, len(body.Participants)) {
+    assert.Equal(t, jaime.participant, body.Participants[0].Id)
+    assert.Equal(t, cersei.participant, body.Participants[1].Id)
+  }
+
+  // the longest waiting moves up when a seat frees
+  doRequest("PUT", "/api/v1/participants/" + robert.participant, robert.sid, `{"participant": {"status": "withdrawn"}}`)
+  assert.Equal(t, ParticipantAccepted, status(jaime))
+  assert.Equal(t, ParticipantWaitlisted, status(cersei))
+  p, _ := store.FindParticipant(jaime.participant)
+  assert.Equal(t, "", p.Transitions[len(p.Transitions) - 1].ActorId)
+
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/participants/" + jaime.participant, jaime.sid, "").Code)
+  assert.Equal(t, ParticipantAccepted, status(cersei))
+
+  // more seats, the withdrawn Robert has to request again
+  doRequest("PUT", "/api/v1/participants/" + robert.participant, robert.sid, `{"participant": {"status": "requested"}}`)
+  assert.Equal(t, 200, accept(robert))
+  assert.Equal(t, ParticipantWaitlisted, status(robert))
+  doRequest("PUT", "/api/v1/users/" + host.Id + "/events/" + event.Id, host_sid, `{"event": {"capacity": "2"}}`)
+  assert.Equal(t, ParticipantAccepted, status(robert))
+}
+
+func TestCapacityUnderConcurrentAccepts(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  event, _ := store.CreateEvent(Event{UserId: host.Id, Title: "Feast", Capacity: 3, CreatedAt: time.Now()})
+
+  ids := []string{}
+  for i := 0; i < 10; i++ {
+    _, sid := newTestUser(t, "Guest")
+    var body struct{ Participant ParticipantWrite }
+    json.Unmarshal(doRequest("POST", "/api/v1/events/" + event.Id + "/participants", sid, "").Body.Bytes(), &body)
+    ids = append(ids, body.Participant.Id)
+  }
+
+  var wg sync.WaitGroup
+  for _, id := range ids {
+    wg.Add(1)
+    go func(id string) {
+      defer wg.Done()
+      doRequest("PUT", "/api/v1/participants/" + id, host_sid, `{"participant": {"status": "accepted"}}`)
+    }(id)
+  }
+  wg.Wait()
+
+  counts := map[string]int{}
+  participants, _ := store.ListEventParticipants(event.Id, Page{Number: 1, Per: 20})
+  for _, p := range participants {
+    counts[p.Status]++
+  }
+  assert.Equal(t, map[string]int{ParticipantAccepted: 3, ParticipantWaitlisted: 7}, counts)
+}
+
+func TestDeleteParticipantKeepsHistory(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  _, guest_sid := newTestUser(t, "Theon")
+  event, _ := store.CreateEvent(Event{UserId: host.Id, Title: "Feast", CreatedAt: time.Now()})
+  url := "/api/v1/events/" + event.Id + "/participants"
+
+  var body struct {
+    Participant ParticipantWrite
+    Created     bool
+  }
+  json.Unmarshal(doRequest("POST", url, guest_sid, "").Body.Bytes(), &body)
+  id := body.Participant.Id
+
+  // withdrawn, not gone: asking again finds the same record
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/participants/" + id, guest_sid, "").Code)
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/participants/" + id, guest_sid, "").Code)
+  json.Unmarshal(doRequest("POST", url, guest_sid, "").Body.Bytes(), &body)
+  assert.False(t, body.Created)
+  assert.Equal(t, id, body.Participant.Id)
+  assert.Equal(t, 3, len(body.Participant.Transitions))
+
+  // a declined user can't start over
+  doRequest("PUT", "/api/v1/participants/" + id, host_sid, `{"participant": {"status": "declined"}}`)
+  resp := doRequest("DELETE", "/api/v1/participants/" + id, guest_sid, "")
+  assert.Equal(t, 409, resp.Code)
+  json.Unmarshal(doRequest("POST", url, guest_sid, "").Body.Bytes(), &body)
+  assert.False(t, body.Created)
+  assert.Equal(t, ParticipantDeclined, body.Participant.Status)
+
+  // nor can a removed one, removed is final
+  doRequest("PUT", "/api/v1/participants/" + id, host_sid, `{"participant": {"status": "accepted"}}`)
+  doRequest("PUT", "/api/v1/participants/" + id, host_sid, `{"participant": {"status": "removed"}}`)
+  assert.Equal(t, 409, doRequest("DELETE", "/api/v1/participants/" + id, guest_sid, "").Code)
+  json.Unmarshal(doRequest("POST", url, guest_sid, "").Body.Bytes(), &body)
+  assert.False(t, body.Created)
+  assert.Equal(t, id, body.Participant.Id)
+  assert.Equal(t, ParticipantRemoved, body.Participant.Status)
+}
+
+func TestDeletesRemoveParticipations(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  event, _ := store.CreateEvent(Event{UserId: host.Id, Title: "Feast", CreatedAt: time.Now()})
+  join := func(first_name string) (User, string, string) {
+    user, sid := newTestUser(t, first_name)
+    var body struct{ Participant ParticipantWrite }
+    json.Unmarshal(doRequest("POST", "/api/v1/events/" + event.Id + "/participants", sid, "").Body.Bytes(), &body)
+    return user, sid, body.Participant.Id
+  }
+  robert, robert_sid, _ := join("Robert")
+  _, _, jaime := join("Jaime")
+
+  // a deleted guest's participations go
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/users/" + robert.Id, robert_sid, "").Code)
+  participants, _ := store.ListUserParticipants(robert.Id, Page{Number: 1, Per: 10})
+  assert.Empty(t, participants)
+
+  // as do a deleted event's
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/users/" + host.Id + "/events/" + event.Id, host_sid, "").Code)
+  _, err := store.FindParticipant(jaime)
+  assert.Equal(t, ErrNotFound, err)
+}
+
+func TestDeletedUsersSeatsGoToTheWaitlist(t *testing.T) {
+  store = NewMemoryStore()
+  host, host_sid := newTestUser(t, "Ned")
+  event, _ := store.CreateEvent(Event{UserId: host.Id, Title: "Feast", Capacity: 1, CreatedAt: time.Now()})
+  join := func(first_name string) (User, string, string) {
+    user, sid := newTestUser(t, first_name)
+    var body struct{ Participant ParticipantWrite }
+    json.Unmarshal(doRequest("POST", "/api/v1/events/" + event.Id + "/participants", sid, "").Body.Bytes(), &body)
+    doRequest("PUT", "/api/v1/participants/" + body.Participant.Id, host_sid, `{"participant": {"status": "accepted"}}`)
+    return user, sid, body.Participant.Id
+  }
+  robert, robert_sid, _ := join("Robert")
+  _, _, jaime := join("Jaime")
+  p, _ := store.FindParticipant(jaime)
+  assert.Equal(t, ParticipantWaitlisted, p.Status)
+
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/users/" + robert.Id, robert_sid, "").Code)
+  p, _ = store.FindParticipant(jaime)
+  assert.Equal(t, ParticipantAccepted, p.Status)
+
+  // a deleted event's seats go with it
+  assert.Equal(t, 200, doRequest("DELETE", "/api/v1/users/" + host.Id + "/events/" + event.Id, host_sid, "").Code)
+  ok, _ := store.ReserveSeat(event.Id, 1)
+  assert.True(t, ok)
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types of PATCH bodies. Plain application/json is read as a merge patch.
+const (
+	mergePatchType = "application/merge-patch+json" // RFC 7396
+	jsonPatchType  = "application/json-patch+json"  // RFC 6902
+)
+
+// patchFields are the fields of a resource PATCH may change, by their JSON
+// name, and whether removing them, or setting them to null, clears them
+type patchFields map[string]bool
+
+var (
+	userPatchFields = patchFields{
+		"first_name": true,
+		"last_name":  true,
+		"email":      true,
+		"avatar":     true,
+		"bio":        true,
+	}
+	eventPatchFields = patchFields{
+		"title":         true, // cleared only to be refused by validateEvent
+		"description":   true,
+		"picture_url":   true,
+		"privacy_level": false, // clearing would make the event public
+		"capacity":      true,
+		"location":      false,
+		"start_date":    true,
+		"end_date":      true,
+		"timezone":      true,
+	}
+	messagePatchFields     = patchFields{"content": false} // delete the message instead
+	participantPatchFields = patchFields{"status": false}
+)
+
+// readUpdate reads the changes a PUT or PATCH makes to resource, a pointer
+// to a record sent as {name: {...}}, into params for the resource's apply.
+// PUT bodies are read by readBody. A PATCH is a JSON Merge Patch or a JSON
+// Patch of the resource as it's answered; the fields it changes are copied
+// to params and those it clears are zeroed on resource, any other change is
+// a 422. Returns whether fields were cleared.
+func readUpdate(name string, resource interface{}, fields patchFields, params *Request, w http.ResponseWriter, req *http.Request) (cleared bool, ok bool) {
+	if req.Method != "PATCH" {
+		return false, readBody(params, w, req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		badRequest(w, "Couldn't read the body: "+err.Error())
+		return false, false
+	}
+	current, err := patchTarget(resource, nil)
+	if err != nil {
+		internalError(w, err)
+		return false, false
+	}
+	// patches change doc in place, before is kept to compare with
+	before, _ := patchTarget(resource, fields)
+	target, _ := patchTarget(resource, fields)
+	doc := map[string]interface{}{name: target}
+
+	var patched interface{}
+	switch patchType(req) {
+	case mergePatchType:
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			badRequest(w, "Malformed JSON body: "+err.Error())
+			return false, false
+		}
+		p, isObject := patch.(map[string]interface{})
+		if !isObject {
+			badRequest(w, "A merge patch must be a JSON object")
+			return false, false
+		}
+		delete(p, "sid") // deprecated, see requestSid
+		patched = mergePatch(doc, p)
+	case jsonPatchType:
+		var ops []patchOp
+		if err := json.Unmarshal(body, &ops); err != nil {
+			badRequest(w, "Malformed JSON Patch: "+err.Error())
+			return false, false
+		}
+		patched, err = applyJSONPatch(doc, ops)
+		if perr, isPatchErr := err.(*patchError); isPatchErr && perr.conflict {
+			conflict(w, perr.Error())
+			return false, false
+		} else if err != nil {
+			badRequest(w, err.Error())
+			return false, false
+		}
+	default:
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		sendError(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+			"PATCH bodies must be "+mergePatchType+" or "+jsonPatchType, nil)
+		return false, false
+	}
+
+	v := Validation{}
+	result, _ := patched.(map[string]interface{})
+	for key := range result {
+		if key != name {
+			v.Add(key, FieldUnknown, key+" isn't a known field")
+		}
+	}
+	after, isObject := result[name].(map[string]interface{})
+	if !isObject {
+		invalidField(w, name, name+" must be an object")
+		return false, false
+	}
+
+	changes := map[string]interface{}{}
+	clear := []string{}
+	for _, key := range patchKeys(before, after) {
+		value := after[key]
+		clearable, mutable := fields[key]
+		was := before[key]
+		if !mutable {
+			was = current[key] // sending back a field as it is changes nothing
+		}
+		if reflect.DeepEqual(was, value) {
+			continue
+		}
+		_, known := current[key]
+		switch {
+		case !mutable && known:
+			v.Add(key, FieldReadOnly, key+" can't be changed")
+		case !mutable:
+			v.Add(key, FieldUnknown, key+" isn't a known field")
+		case value != nil:
+			changes[key] = value
+		case clearable:
+			clear = append(clear, key)
+		default:
+			v.Add(key, FieldRequired, key+" can't be cleared")
+		}
+	}
+
+	js, _ := json.Marshal(map[string]interface{}{name: changes})
+	if ok := decodeParams(js, params, &v, w); !ok {
+		return false, false
+	}
+	if !v.Valid() {
+		sendValidation(v, w)
+		return false, false
+	}
+	for _, key := range clear {
+		clearField(resource, key)
+	}
+	return len(clear) > 0, true
+}
+
+// patchType is the media type of a PATCH body
+func patchType(req *http.Request) string {
+	t, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case err != nil:
+		return mergePatchType // no or unparseable Content-Type, like curl -d sends
+	case t == "application/json":
+		return mergePatchType
+	}
+	return t
+}
+
+// patchTarget is resource as it's answered, limited to fields unless they're nil
+func patchTarget(resource interface{}, fields patchFields) (map[string]interface{}, error) {
+	js, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(js, &doc); err != nil {
+		return nil, err
+	}
+	if fields != nil {
+		for key := range doc {
+			if _, ok := fields[key]; !ok {
+				delete(doc, key)
+			}
+		}
+	}
+	return doc, nil
+}
+
+// patchKeys are the keys of a and b, sorted so errors come in a stable order
+func patchKeys(a, b map[string]interface{}) []string {
+	keys := []string{}
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// clearField sets the field of the struct resource points to whose JSON
+// name is key back to its zero value
+func clearField(resource interface{}, key string) {
+	s := reflect.ValueOf(resource).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		if strings.Split(s.Type().Field(i).Tag.Get("json"), ",")[0] == key {
+			s.Field(i).Set(reflect.Zero(s.Field(i).Type()))
+		}
+	}
+}
+
+//// JSON Merge Patch
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) to target: members of
+// patch replace those of target, objects are merged and nulls remove members
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = mergePatch(t[key], value)
+		}
+	}
+	return t
+}
+
+//// JSON Patch
+
+// patchOp is an operation of a JSON Patch (RFC 6902). Value is the raw
+// JSON so a null value can be told from a missing one.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchError is a JSON Patch that couldn't be applied. Conflicts are
+// patches that are well formed but don't fit the document, like a failed
+// test or a path that isn't there.
+type patchError struct {
+	index    int
+	message  string
+	conflict bool
+}
+
+func (e *patchError) Error() string {
+	return fmt.Sprintf("JSON Patch operation %d: %s", e.index, e.message)
+}
+
+// applyJSONPatch applies ops to doc in order, stopping at the first that fails
+func applyJSONPatch(doc interface{}, ops []patchOp) (interface{}, error) {
+	for i, op := range ops {
+		fail := func(conflict bool, format string, a ...interface{}) (interface{}, error) {
+			return nil, &patchError{i, fmt.Sprintf(format, a...), conflict}
+		}
+
+		path, err := parsePointer(op.Path)
+		if err != nil {
+			return fail(false, "%v", err)
+		}
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return fail(false, "%s needs a value", op.Op)
+			}
+			json.Unmarshal(op.Value, &value)
+		case "move", "copy":
+			from, err := parsePointer(op.From)
+			if err != nil {
+				return fail(false, "%v", err)
+			}
+			if op.Op == "move" && strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return fail(false, "can't move %q into itself", op.From)
+			}
+			if value, err = getPointer(doc, from); err != nil {
+				return fail(true, "%v", err)
+			}
+			if op.Op == "copy" {
+				value = copyJSON(value)
+			} else if doc, err = removePointer(doc, from); err != nil {
+				return fail(true, "%v", err)
+			}
+		case "remove":
+		default:
+			return fail(false, "unknown op %q", op.Op)
+		}
+
+		switch op.Op {
+		case "add", "move", "copy":
+			doc, err = addPointer(doc, path, value)
+		case "remove":
+			doc, err = removePointer(doc, path)
+		case "replace":
+			if doc, err = removePointer(doc, path); err == nil {
+				doc, err = addPointer(doc, path, value)
+			}
+		case "test":
+			var found interface{}
+			found, err = getPointer(doc, path)
+			if err == nil && !reflect.DeepEqual(found, value) {
+				return fail(true, "%q isn't %s", op.Path, op.Value)
+			}
+		}
+		if err != nil {
+			return fail(true, "%v", err)
+		}
+	}
+	return doc, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901), like "/event/title", into
+// its unescaped reference tokens
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return []string{}, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("path %q must start with /", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func getPointer(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			value, ok := c[token]
+			if !ok {
+				return nil, fmt.Errorf("%q isn't there", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, fmt.Errorf("%q isn't there", token)
+		}
+	}
+	return doc, nil
+}
+
+// addPointer adds value at path, returning the new document. Members are
+// set, array elements inserted before the index, or appended for "-".
+func addPointer(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return atPointer(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			if token == "-" {
+				return append(c, value), nil
+			}
+			i, err := arrayIndex(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, fmt.Errorf("can't add %q to a %T", token, parent)
+	})
+}
+
+// removePointer removes what's at path, which must be there
+func removePointer(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("can't remove the whole document")
+	}
+	return atPointer(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := c[token]; !ok {
+				return nil, fmt.Errorf("%q isn't there", token)
+			}
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("%q isn't there", token)
+	})
+}
+
+// atPointer calls f with the parent of path's last token and puts the
+// parent f returns back in its place, arrays change when they grow
+func atPointer(doc interface{}, path []string, f func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return f(doc, path[0])
+	}
+	child, err := getPointer(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = atPointer(child, path[1:], f)
+	if err != nil {
+		return nil, err
+	}
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		c[path[0]] = child
+	case []interface{}:
+		i, _ := strconv.Atoi(path[0])
+		c[i] = child
+	}
+	return doc, nil
+}
+
+// arrayIndex reads an array index token, which may be at most max
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%q isn't an array index", token)
+	}
+	if i > max {
+		return 0, fmt.Errorf("index %d is out of bounds", i)
+	}
+	return i, nil
+}
+
+func copyJSON(value interface{}) interface{} {
+	js, _ := json.Marshal(value)
+	var c interface{}
+	json.Unmarshal(js, &c)
+	return c
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func doPatch(url, sid, contentType, body string) *httptest.ResponseRecorder {
+  resp := httptest.NewRecorder()
+  req, _ := http.NewRequest("PATCH", url, bytes.NewReader([]byte(body)))
+  req.Header.Set("Authorization", "Bearer " + sid)
+  req.Header.Set("Content-Type", contentType)
+  initRouting().ServeHTTP(resp, req)
+  return resp
+}
+
+func TestMergePatch(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Jorah")
+  url := "/api/v1/users/" + user.Id
+
+  resp := doRequest("PUT", url, sid, `{"user": {"bio": "Exiled", "email": "jorah@bear-island.com"}}`)
+  assert.Equal(t, 200, resp.Code)
+
+  // null clears a field, what's left out is kept
+  var body struct{ User User; Changed bool }
+  resp = doPatch(url, sid, mergePatchType, `{"user": {"bio": null, "last_name": "Mormont"}}`)
+  assert.Equal(t, 200, resp.Code, resp.Body.String())
+  json.Unmarshal(resp.Body.Bytes(), &body)
+  assert.True(t, body.Changed)
+  assert.Equal(t, "", body.User.Bio)
+  assert.Equal(t, "Mormont", body.User.LastName)
+  assert.Equal(t, "jorah@bear-island.com", body.User.Email)
+
+  // sending fields back unchanged is fine, changing others isn't
+  resp = doPatch(url, sid, "application/json", `{"user": {"id": "` + user.Id + `", "last_name": "Mormont"}}`)
+  assert.Equal(t, 200, resp.Code, resp.Body.String())
+  json.Unmarshal(resp.Body.Bytes(), &body)
+  assert.False(t, body.Changed)
+  resp = doPatch(url, sid, mergePatchType, `{"user": {"id": "khal", "nickname": "Ser"}, "admin": true}`)
+  assert.Equal(t, map[string]string{"id": FieldReadOnly, "nickname": FieldUnknown, "admin": FieldUnknown}, fieldErrors(t, resp))
+
+  resp = doPatch(url, sid, mergePatchType, `{"user": {"email": "not an email"}}`)
+  assert.Equal(t, map[string]string{"email": FieldInvalid}, fieldErrors(t, resp))
+  resp = doPatch(url, sid, "text/plain", `bio=`)
+  assert.Equal(t, 415, resp.Code)
+  assert.Contains(t, resp.Header().Get("Accept-Patch"), jsonPatchType)
+}
+
+func TestPatchEvents(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Daario")
+  url := "/api/v1/users/" + user.Id + "/events"
+
+  var body struct{ Event Event }
+  resp := doRequest("POST", url, sid, `{"event": {"title": "Yunkai", "capacity": 10, "privacy_level": 1,
+    "timezone": "Asia/Dubai", "start_date": "2017-06-01T20:00:00", "lon": 1, "lat": 2}}`)
+  json.Unmarshal(resp.Body.Bytes(), &body)
+  url += "/" + body.Event.Id
+
+  resp = doPatch(url, sid, mergePatchType, `{"event": {"capacity": null, "timezone": null, "location": [3, 4]}}`)
+  assert.Equal(t, 200, resp.Code, resp.Body.String())
+  json.Unmarshal(resp.Body.Bytes(), &body)
+  assert.Equal(t, 0, body.Event.Capacity)
+  assert.Equal(t, "", body.Event.Timezone)
+  assert.Equal(t, Location{3, 4}, body.Event.Location)
+  assert.Contains(t, resp.Body.String(), `"start_date":"2017-06-01T16:00:00Z"`)
+
+  resp = doPatch(url, sid, mergePatchType, `{"event": {"privacy_level": null, "location": null}}`)
+  assert.Equal(t, map[string]string{"privacy_level": FieldRequired, "location": FieldRequired}, fieldErrors(t, resp))
+  // cleared fields are validated like the rest of the event
+  resp = doPatch(url, sid, mergePatchType, `{"event": {"title": null, "location": [3]}}`)
+  assert.Equal(t, map[string]string{"location": FieldInvalid, "title": FieldRequired}, fieldErrors(t, resp))
+  resp = doPatch(url, sid, mergePatchType, `{"event": {"capacity": "many"}}`)
+  assert.Equal(t, map[string]string{"capacity": FieldInvalid}, fieldErrors(t, resp))
+}
+
+func TestJSONPatch(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  message := postMessage(t, f, f.member, "Valar morghulis", "")
+  url := "/api/v1/messages/" + message.Id
+
+  resp := doPatch(url, f.member, jsonPatchType, `[
+    {"op": "test", "path": "/message/content", "value": "Valar morghulis"},
+    {"op": "replace", "path": "/message/content", "value": "Valar dohaeris"}
+  ]`)
+  assert.Equal(t, 200, resp.Code, resp.Body.String())
+  var body struct{ Message Message }
+  json.Unmarshal(resp.Body.Bytes(), &body)
+  assert.Equal(t, "Valar dohaeris", body.Message.Content)
+
+  // the test fails now that the content changed
+  resp = doPatch(url, f.member, jsonPatchType, `[
+    {"op": "test", "path": "/message/content", "value": "Valar morghulis"},
+    {"op": "remove", "path": "/message/content"}
+  ]`)
+  assert.Equal(t, 409, resp.Code)
+  resp = doPatch(url, f.member, jsonPatchType, `[{"op": "remove", "path": "/message/content"}]`)
+  assert.Equal(t, map[string]string{"content": FieldRequired}, fieldErrors(t, resp))
+  resp = doPatch(url, f.member, jsonPatchType, `[{"op": "add", "path": "/message/references", "value": "x"}]`)
+  assert.Equal(t, map[string]string{"references": FieldReadOnly}, fieldErrors(t, resp))
+  resp = doPatch(url, f.member, jsonPatchType, `[{"op": "jump", "path": "/message"}]`)
+  assert.Equal(t, 400, resp.Code)
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+  var doc interface{}
+  json.Unmarshal([]byte(`{"a": {"b": [1, 2]}, "c~/d": 3}`), &doc)
+  var ops []patchOp
+  json.Unmarshal([]byte(`[
+    {"op": "add", "path": "/a/b/1", "value": 5},
+    {"op": "add", "path": "/a/b/-", "value": 6},
+    {"op": "remove", "path": "/a/b/0"},
+    {"op": "copy", "from": "/c~0~1d", "path": "/e"},
+    {"op": "move", "from": "/c~0~1d", "path": "/a/f"},
+    {"op": "replace", "path": "/e", "value": null}
+  ]`), &ops)
+  doc, err := applyJSONPatch(doc, ops)
+  assert.Nil(t, err)
+  js, _ := json.Marshal(doc)
+  assert.JSONEq(t, `{"a": {"b": [5, 2, 6], "f": 3}, "e": null}`, string(js))
+
+  for _, bad := range []string{
+    `[{"op": "remove", "path": "/a/b/9"}]`,
+    `[{"op": "move", "from": "/a", "path": "/a/g"}]`,
+    `[{"op": "add", "path": "a"}]`,
+  } {
+    json.Unmarshal([]byte(bad), &ops)
+    _, err := applyJSONPatch(doc, ops)
+    assert.NotNil(t, err, bad)
+  }
+}
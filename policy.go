@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Event privacy levels, stored in Event.PrivacyLevel
+const (
+	// PrivacyPublic events, their messages and participants are visible to everyone
+	PrivacyPublic = iota
+	// PrivacyParticipants events can be found and joined by anyone, but only
+	// the host and accepted participants see messages and participants
+	PrivacyParticipants
+	// PrivacyInviteOnly events are only visible to the host and invited users
+	PrivacyInviteOnly
+	// PrivacyPrivate events are only visible to the host
+	PrivacyPrivate
+)
+
+// listedPrivacyLevels are the levels that show up in the events index
+var listedPrivacyLevels = []int{PrivacyPublic, PrivacyParticipants}
+
+func validPrivacyLevel(level int) bool {
+	return level >= PrivacyPublic && level <= PrivacyPrivate
+}
+
+// EventAction is something a user can try to do with an event
+type EventAction int
+
+const (
+	ViewEvent EventAction = iota
+	JoinEvent
+	ReadMessages
+	WriteMessages
+	ListParticipants
+)
+
+// eventRole is how a user is related to an event, from least to most access
+type eventRole int
+
+const (
+	roleStranger eventRole = iota // anonymous, or no participation
+	roleInvited                   // invited, or a request the host hasn't accepted yet
+	roleMember                    // accepted participant
+	roleHost
+)
+
+// eventAllows is the privacy policy: whether a user with role may do action
+// on event. Unknown privacy levels are treated as private.
+func eventAllows(event Event, role eventRole, action EventAction) bool {
+	if role == roleHost {
+		return true
+	}
+
+	switch event.PrivacyLevel {
+	case PrivacyPublic:
+		return true
+	case PrivacyParticipants:
+		if action == ViewEvent || action == JoinEvent {
+			return true
+		}
+		return role == roleMember
+	case PrivacyInviteOnly:
+		if action == ViewEvent || action == JoinEvent {
+			return role >= roleInvited
+		}
+		return role == roleMember
+	}
+	return false
+}
+
+// eventRoleOf works out how user is related to event. The zero User is a stranger.
+func eventRoleOf(event Event, user User) (eventRole, error) {
+	if user.Id == "" {
+		return roleStranger, nil
+	}
+	if user.Id == event.UserId {
+		return roleHost, nil
+	}
+
+	p, err := store.FindEventParticipant(event.Id, user.Id)
+	if err == ErrNotFound {
+		return roleStranger, nil
+	}
+	if err != nil {
+		return roleStranger, err
+	}
+	switch p.Status {
+	case ParticipantAccepted:
+		return roleMember, nil
+	case ParticipantRequested, ParticipantInvited, ParticipantWaitlisted:
+		return roleInvited, nil
+	}
+	return roleStranger, nil
+}
+
+// authorizeEvent checks the session user (if any) may do action on event.
+// Users who may not see the event get a 404 so its existence isn't leaked,
+// otherwise a refused action is a 403.
+func authorizeEvent(event Event, action EventAction, w http.ResponseWriter, req *http.Request) bool {
+	role, err := eventRoleOf(event, currentUser(req))
+	if err != nil {
+		internalError(w, err)
+		return false
+	}
+
+	if !eventAllows(event, role, ViewEvent) {
+		notFound(w, "Event not found")
+		return false
+	}
+	if !eventAllows(event, role, action) {
+		forbidden(w, "Not allowed by the event's privacy level")
+		return false
+	}
+	return true
+}
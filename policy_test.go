@@ -0,0 +1,151 @@
+package main
+
+import (
+  "encoding/json"
+  "strconv"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestEventAllows(t *testing.T) {
+  // stranger, invited, member for each action
+  expected := map[int]map[EventAction][3]bool{
+    PrivacyPublic: {
+      ViewEvent:        {true, true, true},
+      JoinEvent:        {true, true, true},
+      ReadMessages:     {true, true, true},
+      WriteMessages:    {true, true, true},
+      ListParticipants: {true, true, true},
+    },
+    PrivacyParticipants: {
+      ViewEvent:        {true, true, true},
+      JoinEvent:        {true, true, true},
+      ReadMessages:     {false, false, true},
+      WriteMessages:    {false, false, true},
+      ListParticipants: {false, false, true},
+    },
+    PrivacyInviteOnly: {
+      ViewEvent:        {false, true, true},
+      JoinEvent:        {false, true, true},
+      ReadMessages:     {false, false, true},
+      WriteMessages:    {false, false, true},
+      ListParticipants: {false, false, true},
+    },
+    PrivacyPrivate: {
+      ViewEvent:        {false, false, false},
+      JoinEvent:        {false, false, false},
+      ReadMessages:     {false, false, false},
+      WriteMessages:    {false, false, false},
+      ListParticipants: {false, false, false},
+    },
+  }
+
+  for level, actions := range expected {
+    event := Event{PrivacyLevel: level}
+    for action, allowed := range actions {
+      for i, role := range []eventRole{roleStranger, roleInvited, roleMember} {
+        assert.Equal(t, allowed[i], eventAllows(event, role, action), "level %d action %d role %d", level, action, role)
+      }
+      assert.True(t, eventAllows(event, roleHost, action), "host, level %d action %d", level, action)
+    }
+  }
+
+  // unknown levels are private
+  assert.False(t, eventAllows(Event{PrivacyLevel: 42}, roleMember, ViewEvent))
+}
+
+// privacyFixture is an event at a privacy level with a host, an accepted
+// participant, a pending participant and a stranger
+type privacyFixture struct {
+  event                           Event
+  host, member, pending, stranger string // sids
+}
+
+func newPrivacyFixture(t *testing.T, level int) privacyFixture {
+  store = NewMemoryStore()
+  f := privacyFixture{}
+  var host, member, pending User
+  host, f.host = newTestUser(t, "Daenerys")
+  member, f.member = newTestUser(t, "Jorah")
+  pending, f.pending = newTestUser(t, "Daario")
+  _, f.stranger = newTestUser(t, "Viserys")
+
+  now := time.Now()
+  f.event, _ = store.CreateEvent(Event{UserId: host.Id, Title: "Dragons", PrivacyLevel: level, CreatedAt: now, UpdatedAt: now})
+  store.CreateParticipant(ParticipantWrite{EventId: f.event.Id, UserId: member.Id, Status: ParticipantAccepted})
+  store.CreateParticipant(ParticipantWrite{EventId: f.event.Id, UserId: pending.Id, Status: ParticipantRequested})
+  store.CreateMessage(Message{EventId: f.event.Id, UserId: host.Id, Content: "Dracarys", CreatedAt: now, UpdatedAt: now})
+  return f
+}
+
+func TestPrivacyLevelsOverHTTP(t *testing.T) {
+  // status codes for anonymous, stranger, pending, member, host
+  type codes [5]int
+  cases := []struct {
+    level    int
+    listed   bool
+    show     codes
+    messages codes
+    post     codes
+    people   codes
+  }{
+    {PrivacyPublic, true,
+      codes{200, 200, 200, 200, 200},
+      codes{401, 200, 200, 200, 200},
+      codes{401, 200, 200, 200, 200},
+      codes{401, 200, 200, 200, 200}},
+    {PrivacyParticipants, true,
+      codes{200, 200, 200, 200, 200},
+      codes{401, 403, 403, 200, 200},
+      codes{401, 403, 403, 200, 200},
+      codes{401, 403, 403, 200, 200}},
+    {PrivacyInviteOnly, false,
+      codes{404, 404, 200, 200, 200},
+      codes{401, 404, 403, 200, 200},
+      codes{401, 404, 403, 200, 200},
+      codes{401, 404, 403, 200, 200}},
+    {PrivacyPrivate, false,
+      codes{404, 404, 404, 404, 200},
+      codes{401, 404, 404, 404, 200},
+      codes{401, 404, 404, 404, 200},
+      codes{401, 404, 404, 404, 200}},
+  }
+
+  for _, c := range cases {
+    f := newPrivacyFixture(t, c.level)
+    level := "level " + strconv.Itoa(c.level)
+    for i, sid := range []string{"", f.stranger, f.pending, f.member, f.host} {
+      url := "/api/v1/events/" + f.event.Id
+      assert.Equal(t, c.show[i], doRequest("GET", url, sid, "").Code, level + " show")
+      assert.Equal(t, c.messages[i], doRequest("GET", url + "/messages", sid, "").Code, level + " messages")
+      assert.Equal(t, c.post[i], doRequest("POST", url + "/messages", sid, `{"message": {"content": "hi"}}`).Code, level + " post")
+      assert.Equal(t, c.people[i], doRequest("GET", url + "/participants", sid, "").Code, level + " participants")
+    }
+
+    resp := doRequest("GET", "/api/v1/events", "", "")
+    res := struct {
+      Events []Event `json:"events"`
+    }{}
+    json.Unmarshal(resp.Body.Bytes(), &res)
+    assert.Equal(t, c.listed, len(res.Events) == 1, level + " listed")
+  }
+}
+
+func TestJoinInviteOnlyEvent(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyInviteOnly)
+  resp := doRequest("POST", "/api/v1/events/" + f.event.Id + "/participants", f.stranger, "")
+  assert.Equal(t, 404, resp.Code)
+
+  f = newPrivacyFixture(t, PrivacyParticipants)
+  resp = doRequest("POST", "/api/v1/events/" + f.event.Id + "/participants", f.stranger, "")
+  assert.Equal(t, 200, resp.Code)
+}
+
+func TestInvalidPrivacyLevel(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Tyrion")
+  resp := doRequest("POST", "/api/v1/users/" + user.Id + "/events", sid, `{"event": {"title": "Trial", "privacy_level": "7"}}`)
+  assert.Equal(t, 422, resp.Code)
+}
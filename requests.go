@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Request is the body of write requests: the object of the resource being
+// written under its name, {"event": {...}}. Resource fields are pointers so
+// updates can tell a field that wasn't sent from one sent empty.
+//
+// Numbers may be JSON numbers or, the way clients of the old string map
+// params send them, strings: "capacity": 50 and "capacity": "50" are the
+// same. See Int and Float.
+type Request struct {
+	User        UserRequest        `json:"user"`
+	Event       EventRequest       `json:"event"`
+	Message     MessageRequest     `json:"message"`
+	Participant ParticipantRequest `json:"participant"`
+	Invitation  InvitationRequest  `json:"invitation"`
+	InviteLink  InviteLinkRequest  `json:"invite_link"`
+	Moderation  ModerationRequest  `json:"moderation"`
+	Mute        MuteRequest        `json:"mute"`
+	Device      Device             `json:"device"`
+	Provider    string             `json:"provider"`
+	AccessToken string             `json:"access_token"`
+	Sid         string             `json:"sid"` // deprecated, see requestSid
+}
+
+type UserRequest struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+	Avatar    *string `json:"avatar"`
+	Bio       *string `json:"bio"`
+}
+
+type EventRequest struct {
+	Title        *string   `json:"title"`
+	Description  *string   `json:"description"`
+	PictureUrl   *string   `json:"picture_url"`
+	PrivacyLevel *Int      `json:"privacy_level"`
+	Capacity     *Int      `json:"capacity"`
+	Lon          *Float    `json:"lon"`
+	Lat          *Float    `json:"lat"`
+	Location     *Location `json:"location"` // [lon, lat], as events are answered
+	StartDate    *string   `json:"start_date"`
+	EndDate      *string   `json:"end_date"`
+	Timezone     *string   `json:"timezone"`
+}
+
+type MessageRequest struct {
+	Content    *string `json:"content"`
+	References *string `json:"references"`
+}
+
+// ParticipantRequest moves a participant to Status. RequestStatus and
+// ResponseStatus are the fields older clients set, see requestedParticipantStatus.
+type ParticipantRequest struct {
+	Status         *string `json:"status"`
+	RequestStatus  *string `json:"request_status"`
+	ResponseStatus *string `json:"response_status"`
+}
+
+type InvitationRequest struct {
+	UserId *string `json:"user_id"`
+	Email  *string `json:"email"`
+}
+
+type InviteLinkRequest struct {
+	ExpiresIn *string `json:"expires_in"` // a Go duration, like "48h"
+}
+
+type ModerationRequest struct {
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+type MuteRequest struct {
+	UserId string `json:"user_id"`
+	Reason string `json:"reason"`
+}
+
+// apply sets the fields of u that r has, returning whether there were any
+func (r UserRequest) apply(u *User) bool {
+	changed := false
+	for _, f := range []struct {
+		src *string
+		dst *string
+	}{
+		{r.FirstName, &u.FirstName},
+		{r.LastName, &u.LastName},
+		{r.Email, &u.Email},
+		{r.Avatar, &u.Avatar},
+		{r.Bio, &u.Bio},
+	} {
+		if f.src != nil {
+			*f.dst, changed = *f.src, true
+		}
+	}
+	return changed
+}
+
+// apply sets the fields of m that r has, returning whether there were any
+func (r MessageRequest) apply(m *Message) bool {
+	changed := false
+	if r.Content != nil {
+		m.Content, changed = *r.Content, true
+	}
+	if r.References != nil {
+		m.References, changed = *r.References, true
+	}
+	return changed
+}
+
+// apply sets the fields of e that r has, returning whether there were any.
+// Fields that don't parse are added to v and leave e as it was. Dates are
+// read in the event's timezone, the one r sets if it does, and both dates
+// are moved to it, see parseTime.
+func (r EventRequest) apply(e *Event, v *Validation) bool {
+	changed := v.Timezone("timezone", r.Timezone, &e.Timezone)
+	loc := e.location()
+	for _, f := range []struct {
+		src *string
+		dst *string
+	}{
+		{r.Title, &e.Title},
+		{r.Description, &e.Description},
+		{r.PictureUrl, &e.PictureUrl},
+	} {
+		if f.src != nil {
+			*f.dst, changed = *f.src, true
+		}
+	}
+	if r.Location != nil && len(*r.Location) != 2 {
+		v.Add("location", FieldInvalid, "location must be [lon, lat]")
+	} else if r.Location != nil {
+		e.Location, changed = *r.Location, true
+	}
+	for _, given := range []bool{
+		v.Int("privacy_level", r.PrivacyLevel, &e.PrivacyLevel),
+		v.Int("capacity", r.Capacity, &e.Capacity),
+		v.Location(r.Lon, r.Lat, &e.Location),
+		v.Time("start_date", r.StartDate, loc, &e.StartDate),
+		v.Time("end_date", r.EndDate, loc, &e.EndDate),
+	} {
+		changed = changed || given
+	}
+	e.StartDate, e.EndDate = inZone(e.StartDate, loc), inZone(e.EndDate, loc)
+	return changed
+}
+
+//// Field types
+
+// Int is a whole number, sent as a JSON number or a string holding one. A
+// value that's neither doesn't fail decoding the whole body, it's kept in
+// Invalid for Validation.Int to report with the payload's other problems.
+type Int struct {
+	Value   int
+	Invalid string
+}
+
+func (i *Int) UnmarshalJSON(b []byte) error {
+	n, err := strconv.Atoi(jsonNumber(b))
+	if err != nil {
+		i.Invalid = string(b)
+		return nil
+	}
+	i.Value = n
+	return nil
+}
+
+// Float is a number, sent as a JSON number or a string holding one, see Int
+type Float struct {
+	Value   float64
+	Invalid string
+}
+
+func (f *Float) UnmarshalJSON(b []byte) error {
+	n, err := strconv.ParseFloat(jsonNumber(b), 64)
+	if err != nil {
+		f.Invalid = string(b)
+		return nil
+	}
+	f.Value = n
+	return nil
+}
+
+// jsonNumber is the text of a JSON number, or of a string holding one
+func jsonNumber(b []byte) string {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		return strings.TrimSpace(s)
+	}
+	return string(b)
+}
+
+//// Strict mode
+
+// wantsStrict reports whether the client asked for fields the server doesn't
+// know to be refused rather than ignored, with "Prefer: handling=strict"
+// (RFC 7240)
+func wantsStrict(prefer []string) bool {
+	for _, header := range prefer {
+		for _, p := range strings.Split(header, ",") {
+			if strings.EqualFold(strings.Replace(strings.TrimSpace(p), " ", "", -1), "handling=strict") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unknownFields lists the keys of the JSON object data, and of the objects
+// nested in it, that t, a struct type, has no field for. Nested keys are
+// named by their path, like "event.titel".
+func unknownFields(data []byte, t reflect.Type, prefix string) []string {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil {
+		return nil
+	}
+
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+
+	unknown := []string{}
+	for key, value := range object {
+		ft, ok := fields[key]
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		// Int and Float read their own JSON
+		if ft.Kind() == reflect.Struct && !reflect.PtrTo(ft).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+			unknown = append(unknown, unknownFields(value, ft, prefix+key+".")...)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestTypedAndLegacyPayloads(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Podrick")
+  url := "/api/v1/users/" + user.Id + "/events"
+
+  var body struct{ Event Event }
+  for _, payload := range []string{
+    `{"event": {"title": "Tourney", "privacy_level": 1, "capacity": 50, "lon": -75.16, "lat": 39.95}}`,
+    `{"event": {"title": "Tourney", "privacy_level": "1", "capacity": "50", "lon": "-75.16", "lat": "39.95"}}`,
+  } {
+    resp := doRequest("POST", url, sid, payload)
+    assert.Equal(t, 200, resp.Code, resp.Body.String())
+    json.Unmarshal(resp.Body.Bytes(), &body)
+    assert.Equal(t, PrivacyParticipants, body.Event.PrivacyLevel)
+    assert.Equal(t, 50, body.Event.Capacity)
+    assert.Equal(t, Location{-75.16, 39.95}, body.Event.Location)
+  }
+
+  // fields left out of an update are left alone
+  resp := doRequest("PUT", url + "/" + body.Event.Id, sid, `{"event": {"capacity": 0}}`)
+  assert.Equal(t, 200, resp.Code, resp.Body.String())
+  json.Unmarshal(resp.Body.Bytes(), &body)
+  assert.Equal(t, 0, body.Event.Capacity)
+  assert.Equal(t, "Tourney", body.Event.Title)
+  assert.Equal(t, PrivacyParticipants, body.Event.PrivacyLevel)
+
+  // a number isn't taken for a string field, nor a fraction for a whole number
+  resp = doRequest("POST", url, sid, `{"event": {"title": 7}}`)
+  assert.Equal(t, map[string]string{"event.title": FieldInvalid}, fieldErrors(t, resp))
+  resp = doRequest("POST", url, sid, `{"event": {"title": "Tourney", "capacity": 1.5}}`)
+  assert.Equal(t, map[string]string{"capacity": FieldInvalid}, fieldErrors(t, resp))
+}
+
+func TestStrictPayloads(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Bronn")
+  url := "/api/v1/users/" + user.Id + "/events"
+  payload := `{"event": {"titel": "Blackwater", "title": "Blackwater"}, "hurry": true}`
+
+  strict := func(prefer string) *httptest.ResponseRecorder {
+    req, _ := http.NewRequest("POST", url, strings.NewReader(payload))
+    req.Header.Set("Authorization", "Bearer " + sid)
+    req.Header.Set("Prefer", prefer)
+    resp := httptest.NewRecorder()
+    initRouting().ServeHTTP(resp, req)
+    return resp
+  }
+
+  // unknown fields are ignored unless the client asks otherwise
+  assert.Equal(t, 200, doRequest("POST", url, sid, payload).Code)
+
+  resp := strict("respond-async, handling=strict")
+  assert.Equal(t, "handling=strict", resp.Header().Get("Preference-Applied"))
+  assert.Equal(t, map[string]string{"event.titel": FieldUnknown, "hurry": FieldUnknown}, fieldErrors(t, resp))
+
+  assert.Equal(t, 200, strict("handling=lenient").Code)
+  assert.Equal(t, 200, strict("").Code)
+}
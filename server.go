@@ -1,18 +1,28 @@
 // Routes
 //    ** MISC **
 //    GET     /ping                              StatusHandler
+//    GET     /config                            ConfigHandler (only with -debug)
+//
+//    ** SESSIONS **
+//    GET     /api/:v/sessions                   IndexSessionsHandler
+//    POST    /api/:v/sessions/:id/refresh       RefreshSessionHandler
+//    DELETE  /api/:v/sessions/:id               DeleteSessionHandler
+//    POST    /api/:v/device                     CreateDeviceHandler
+//    DELETE  /api/:v/device                     DeleteDeviceHandler
 //
 //    ** USERS **
 //    GET     /api/:v/users                      IndexUsersHandler
 //    POST    /api/:v/users                      CreateUserHandler
 //    GET     /api/:v/users/:id                  ShowUserHandler
 //    PUT     /api/:v/users/:id                  UpdateUserHandler
+//    PATCH   /api/:v/users/:id                  UpdateUserHandler
 //    DELETE  /api/:v/users/:id                  DeleteUserHandler
 //
 //    ** EVENTS **
 //    GET     /api/:v/users/:user_id/events      IndexUserEventsHandler
 //    POST    /api/:v/users/:user_id/events      CreateUserEventHandler
 //    PUT     /api/:v/users/:user_id/events/:id  UpdateUserEventHandler
+//    PATCH   /api/:v/users/:user_id/events/:id  UpdateUserEventHandler
 //    DELETE  /api/:v/users/:user_id/events/:id  DeleteUserEventHandler
 //    GET     /api/:v/events/:id                 ShowEventHandler
 //    GET     /api/:v/events                     IndexEventsHandler
@@ -20,48 +30,63 @@
 //    ** MESSAGES **
 //    POST    /api/:v/events/:event_id/messages  CreateEventMessageHandler
 //    GET     /api/:v/events/:event_id/messages  IndexEventMessagesHandler
+//    GET     /api/:v/events/:event_id/messages/stream  StreamEventMessagesHandler (WebSocket or SSE)
+//    GET     /api/:v/messages/:id/replies       IndexMessageRepliesHandler
+//    GET     /api/:v/messages/:id/revisions     IndexMessageRevisionsHandler
+//    POST    /api/:v/messages/:id/moderation    ModerateMessageHandler
 //    DELETE  /api/:v/messages/:id               DeleteMessageHandler
 //    PUT     /api/:v/messages/:id               UpdateMessageHandler
+//    PATCH   /api/:v/messages/:id               UpdateMessageHandler
 //    GET     /api/:v/messages                   IndexUserMessagesHandler
 //
 //     ** PARTICIPANTS **
 //     POST   /api/:v/events/:event_id/participants  CreateEventParticipantHandler
 //     GET    /api/:v/events/:event_id/participants  IndexEventParticipantsHandler
+//     GET    /api/:v/events/:event_id/waitlist      IndexEventWaitlistHandler
 //     DELETE /api/:v/participants/:id               DeleteParticipantHandler
 //     PUT    /api/:v/participants/:id               UpdateParticipantHandler
+//     PATCH  /api/:v/participants/:id               UpdateParticipantHandler
 //     GET    /api/:v/participants                   IndexUserParticipantsHandler
+//
+//     ** INVITATIONS **
+//     POST   /api/:v/events/:event_id/invitations   CreateEventInvitationHandler
+//     POST   /api/:v/events/:event_id/invite_links  CreateEventInviteLinkHandler
+//     POST   /api/:v/invite_links/:token            RedeemInviteLinkHandler
+//     DELETE /api/:v/invite_links/:token            DeleteInviteLinkHandler
+//
+//     ** MODERATION **
+//     GET    /api/:v/events/:event_id/pins          IndexEventPinsHandler
+//     GET    /api/:v/events/:event_id/mutes         IndexEventMutesHandler
+//     POST   /api/:v/events/:event_id/mutes         CreateEventMuteHandler
+//     DELETE /api/:v/events/:event_id/mutes/:user_id  DeleteEventMuteHandler
+//     GET    /api/:v/events/:event_id/moderation    IndexEventModerationHandler
+//
+//     ** NOTIFICATIONS **
+//     GET    /api/:v/notifications/stream           StreamNotificationsHandler (SSE)
+//
+// Every route except /ping, /config, the USERS index/create/show routes and
+// the EVENTS index/show routes requires a session, sent as
+// "Authorization: Bearer <sid>". See authenticate in auth.go. What a user
+// may see of an event depends on its privacy_level, see policy.go. PATCH
+// routes take a JSON Merge Patch or a JSON Patch, see readUpdate in patch.go.
 
 package main
 
 import (
 	"github.com/bmizerany/pat"
-	r "github.com/dancannon/gorethink"
 	"log"
 	"net/http"
 )
 
+// TimeFormat is how dates were sent before RFC 3339, see parseTime
 const TimeFormat string = "Mon Jan 2 2006 15:04:05 MST-07:00"
 const CurrVersion string = "v0.6.2"
 
 var (
-	router  *pat.PatternServeMux
-	session *r.Session
+	router http.Handler
+	store  Store
 )
 
-func init() {
-	var err error
-
-	log.Println("Starting up")
-	session, err = r.Connect(r.ConnectOpts{
-		Address:  "db:28015",
-		Database: "gadder",
-		// AuthKey:  "THIS_IS_A_FAKE_KEY",
-	})
-	if err != nil {
-		log.Fatalln(err.Error())
-	}
-}
-
 func NewServer(addr string) *http.Server {
 	// Setup router
 	router = initRouting()
@@ -69,53 +94,137 @@ func NewServer(addr string) *http.Server {
 
 	// Create and start server
 	return &http.Server{
-		Addr: addr,
+		Addr:         addr,
+		ReadTimeout:  config.Server.ReadTimeout.Duration,
+		WriteTimeout: config.Server.WriteTimeout.Duration,
 	}
 }
 
 func StartServer(server *http.Server) {
 	err := server.ListenAndServe()
 	if err != nil {
-		log.Fatalln("Error: %v", err)
+		errLog.Fatalf("Error: %v\n", err)
+	}
+}
+
+// routeMethods are the methods a path can be answered with a 405 for
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// routes is a pat router that remembers which methods each pattern is
+// routed for. pat answers the other methods with a plain text 405 and an
+// Allow header; allowMethods answers them with the JSON error envelope
+// instead, keeping the Allow header.
+type routes struct {
+	*pat.PatternServeMux
+	patterns []string
+	methods  map[string][]string
+}
+
+func newRoutes() *routes {
+	return &routes{PatternServeMux: pat.New(), methods: make(map[string][]string)}
+}
+
+func (m *routes) Add(method, pattern string, h http.Handler) {
+	if _, ok := m.methods[pattern]; !ok {
+		m.patterns = append(m.patterns, pattern)
+	}
+	m.methods[pattern] = append(m.methods[pattern], method)
+	m.PatternServeMux.Add(method, pattern, h)
+}
+
+// Get routes HEAD too, like pat does
+func (m *routes) Get(pattern string, h http.Handler) {
+	m.Add("GET", pattern, h)
+	m.Add("HEAD", pattern, h)
+}
+func (m *routes) Post(pattern string, h http.Handler)  { m.Add("POST", pattern, h) }
+func (m *routes) Put(pattern string, h http.Handler)   { m.Add("PUT", pattern, h) }
+func (m *routes) Patch(pattern string, h http.Handler) { m.Add("PATCH", pattern, h) }
+func (m *routes) Del(pattern string, h http.Handler)   { m.Add("DELETE", pattern, h) }
+
+// allowMethods routes every method a pattern isn't routed for to
+// methodNotAllowed. It has to come after the real routes, pat tries patterns in the order
+// they were added.
+func (m *routes) allowMethods() {
+	for _, pattern := range m.patterns {
+		allowed := m.methods[pattern]
+		routed := map[string]bool{}
+		for _, method := range allowed {
+			routed[method] = true
+		}
+		for _, method := range routeMethods {
+			if !routed[method] {
+				m.PatternServeMux.Add(method, pattern, methodNotAllowed(allowed))
+			}
+		}
 	}
 }
 
 // Notes: A trailing slash on index route will grab both index and show routes
-func initRouting() *pat.PatternServeMux {
-	// TODO: once we add better session authentication we can remove the user from the routes and use that
-	m := pat.New()
+func initRouting() http.Handler {
+	m := newRoutes()
+	m.NotFound = http.HandlerFunc(routeNotFound)
 	//// Misc
 	m.Get("/ping", http.HandlerFunc(StatusHandler))
+	if config.Debug {
+		m.Get("/config", http.HandlerFunc(ConfigHandler))
+	}
 	//// API
 	// Authentication
-	// m.Post("/api/:v/device",   http.HandlerFunc(createDeviceHandler))
-	// m.Del("/api/:v/device",    http.HandlerFunc(deleteDeviceHandler))
+	m.Post("/api/:v/device", authenticate(CreateDeviceHandler))
+	m.Del("/api/:v/device", authenticate(DeleteDeviceHandler))
+	m.Get("/api/:v/sessions", authenticate(IndexSessionsHandler))
+	m.Post("/api/:v/sessions/:id/refresh", authenticate(RefreshSessionHandler))
+	m.Del("/api/:v/sessions/:id", authenticate(DeleteSessionHandler))
 	// Users
 	m.Get("/api/:v/users", http.HandlerFunc(IndexUsersHandler))
 	m.Post("/api/:v/users", http.HandlerFunc(CreateUserHandler))
 	m.Get("/api/:v/users/:id", http.HandlerFunc(ShowUserHandler))
-	m.Put("/api/:v/users/:id", http.HandlerFunc(UpdateUserHandler))
-	m.Del("/api/:v/users/:id", http.HandlerFunc(DeleteUserHandler))
+	m.Put("/api/:v/users/:id", authenticate(UpdateUserHandler))
+	m.Patch("/api/:v/users/:id", authenticate(UpdateUserHandler))
+	m.Del("/api/:v/users/:id", authenticate(DeleteUserHandler))
 	// Events
-	m.Post("/api/:v/users/:user_id/events", http.HandlerFunc(CreateUserEventHandler))
-	m.Put("/api/:v/users/:user_id/events/:id", http.HandlerFunc(UpdateUserEventHandler))
-	m.Del("/api/:v/users/:user_id/events/:id", http.HandlerFunc(DeleteUserEventHandler))
-	m.Get("/api/:v/users/:user_id/events", http.HandlerFunc(IndexUserEventsHandler))
-	m.Get("/api/:v/events/:id", http.HandlerFunc(ShowEventHandler))
-	m.Get("/api/:v/events", http.HandlerFunc(IndexEventsHandler))
+	m.Post("/api/:v/users/:user_id/events", authenticate(CreateUserEventHandler))
+	m.Put("/api/:v/users/:user_id/events/:id", authenticate(UpdateUserEventHandler))
+	m.Patch("/api/:v/users/:user_id/events/:id", authenticate(UpdateUserEventHandler))
+	m.Del("/api/:v/users/:user_id/events/:id", authenticate(DeleteUserEventHandler))
+	m.Get("/api/:v/users/:user_id/events", authenticate(IndexUserEventsHandler))
+	m.Get("/api/:v/events/:id", optionalAuthenticate(ShowEventHandler))
+	m.Get("/api/:v/events", optionalAuthenticate(IndexEventsHandler))
 	// Messages
-	m.Post("/api/:v/events/:event_id/messages", http.HandlerFunc(CreateEventMessageHandler))
-	m.Get("/api/:v/events/:event_id/messages", http.HandlerFunc(IndexEventMessagesHandler))
-	m.Del("/api/:v/messages/:id", http.HandlerFunc(DeleteMessageHandler))
-	m.Put("/api/:v/messages/:id", http.HandlerFunc(UpdateMessageHandler))
-	m.Get("/api/:v/messages", http.HandlerFunc(IndexUserMessagesHandler))
+	m.Post("/api/:v/events/:event_id/messages", authenticate(CreateEventMessageHandler))
+	m.Get("/api/:v/events/:event_id/messages", authenticate(IndexEventMessagesHandler))
+	m.Get("/api/:v/events/:event_id/messages/stream", authenticate(StreamEventMessagesHandler))
+	m.Get("/api/:v/messages/:id/replies", authenticate(IndexMessageRepliesHandler))
+	m.Get("/api/:v/messages/:id/revisions", authenticate(IndexMessageRevisionsHandler))
+	m.Post("/api/:v/messages/:id/moderation", authenticate(ModerateMessageHandler))
+	m.Del("/api/:v/messages/:id", authenticate(DeleteMessageHandler))
+	m.Put("/api/:v/messages/:id", authenticate(UpdateMessageHandler))
+	m.Patch("/api/:v/messages/:id", authenticate(UpdateMessageHandler))
+	m.Get("/api/:v/messages", authenticate(IndexUserMessagesHandler))
 	// Participants
-	m.Post("/api/:v/events/:event_id/participants", http.HandlerFunc(CreateEventParticipantHandler))
-	m.Get("/api/:v/events/:event_id/participants", http.HandlerFunc(IndexEventParticipantsHandler))
-	m.Del("/api/:v/participants/:id", http.HandlerFunc(DeleteParticipantHandler))
-	m.Put("/api/:v/participants/:id", http.HandlerFunc(UpdateParticipantHandler))
-	m.Get("/api/:v/participants", http.HandlerFunc(IndexUserParticipantsHandler))
+	m.Post("/api/:v/events/:event_id/participants", authenticate(CreateEventParticipantHandler))
+	m.Get("/api/:v/events/:event_id/participants", authenticate(IndexEventParticipantsHandler))
+	m.Get("/api/:v/events/:event_id/waitlist", authenticate(IndexEventWaitlistHandler))
+	m.Del("/api/:v/participants/:id", authenticate(DeleteParticipantHandler))
+	m.Put("/api/:v/participants/:id", authenticate(UpdateParticipantHandler))
+	m.Patch("/api/:v/participants/:id", authenticate(UpdateParticipantHandler))
+	m.Get("/api/:v/participants", authenticate(IndexUserParticipantsHandler))
+	// Invitations
+	m.Post("/api/:v/events/:event_id/invitations", authenticate(CreateEventInvitationHandler))
+	m.Post("/api/:v/events/:event_id/invite_links", authenticate(CreateEventInviteLinkHandler))
+	m.Post("/api/:v/invite_links/:token", authenticate(RedeemInviteLinkHandler))
+	m.Del("/api/:v/invite_links/:token", authenticate(DeleteInviteLinkHandler))
+	// Moderation
+	m.Get("/api/:v/events/:event_id/pins", authenticate(IndexEventPinsHandler))
+	m.Get("/api/:v/events/:event_id/mutes", authenticate(IndexEventMutesHandler))
+	m.Post("/api/:v/events/:event_id/mutes", authenticate(CreateEventMuteHandler))
+	m.Del("/api/:v/events/:event_id/mutes/:user_id", authenticate(DeleteEventMuteHandler))
+	m.Get("/api/:v/events/:event_id/moderation", authenticate(IndexEventModerationHandler))
+	// Notifications
+	m.Get("/api/:v/notifications/stream", authenticate(StreamNotificationsHandler))
 	//
+	m.allowMethods()
 	log.Println("Creating Routes")
-	return m
+	return withRequestId(m)
 }
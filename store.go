@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when the requested record doesn't exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned by a Store when a record with the same id already exists
+var ErrDuplicate = errors.New("duplicate primary key")
+
+// ErrConflict is returned by a Store when a record changed since it was read
+var ErrConflict = errors.New("record changed concurrently")
+
+// List methods taking a Page return records oldest first by created_at, then
+// id, unless noted otherwise. Page cursors are positions in that order.
+
+// UserStore persists User records
+type UserStore interface {
+	ListUsers(page Page) ([]User, error)
+	FindUser(id string) (User, error)
+	FindUserByFacebookId(facebookId string) (User, error)
+	FindUserByEmail(email string) (User, error)
+	CreateUser(user User) (User, error)
+	UpdateUser(user User) error
+	DeleteUser(id string) error
+}
+
+// EventStore persists Event records. ListEvents, ListNearbyEvents and
+// ListEventsWithin only return events whose PrivacyLevel is one of privacyLevels.
+// ListNearbyEvents orders events within radiusKm of lat, lon by distance and
+// only pages by offset.
+// ListEventsWithin returns the events inside any of areas, each once. Area
+// edges are straight lines in lon/lat, see Polygon.contains.
+type EventStore interface {
+	ListEvents(privacyLevels []int, page Page) ([]Event, error)
+	ListNearbyEvents(lat, lon, radiusKm float64, privacyLevels []int, page, per int) ([]NearbyEvent, error)
+	ListEventsWithin(areas []Polygon, privacyLevels []int, page Page) ([]Event, error)
+	ListUserEvents(userId string, page Page) ([]Event, error)
+	FindEvent(id string) (Event, error)
+	CreateEvent(event Event) (Event, error)
+	UpdateEvent(event Event) error
+	DeleteEvent(id string) error
+	DeleteUserEvents(userId string) error
+}
+
+// MessageStore persists Message records. WatchEventMessages streams the
+// changes to an event's messages until stop is closed; the channel is closed
+// when the stream ends.
+type MessageStore interface {
+	ListUserMessages(userId string, page Page) ([]Message, error)
+	ListEventMessages(eventId string, page Page) ([]Message, error)
+	// ListEventThreads returns the messages of an event that aren't replies
+	ListEventThreads(eventId string, page Page) ([]Message, error)
+	ListMessageReplies(id string, page Page) ([]Message, error)
+	// ListRepliesTo returns every reply to any of ids, oldest first
+	ListRepliesTo(ids []string) ([]Message, error)
+	// CountReplies counts the replies to each of ids. Messages without
+	// replies may be missing from the result.
+	CountReplies(ids []string) (map[string]int, error)
+	FindMessage(id string) (Message, error)
+	CreateMessage(message Message) (Message, error)
+	UpdateMessage(message Message) error
+	// ReviseMessage saves message, an edit or tombstone of a stored message,
+	// and keeps revision, the content it replaces
+	ReviseMessage(message Message, revision MessageRevision) error
+	// ListMessageRevisions returns the earlier contents of a message, oldest first
+	ListMessageRevisions(id string) ([]MessageRevision, error)
+	// SetMessageFlag sets the message's FlagHidden or FlagPinned field to
+	// at, or clears it when at is nil, and returns the updated message
+	SetMessageFlag(id, flag string, at *time.Time) (Message, error)
+	// ListEventPins returns an event's pinned messages, most recently pinned first
+	ListEventPins(eventId string) ([]Message, error)
+	// DeleteMessage, DeleteUserMessages and DeleteEventMessages remove
+	// messages and their revisions for good, without leaving tombstones
+	DeleteMessage(id string) error
+	DeleteUserMessages(userId string) error
+	DeleteEventMessages(eventId string) error
+	WatchEventMessages(eventId string, stop <-chan struct{}) (<-chan MessageChange, error)
+}
+
+// ParticipantStore persists participation requests. List methods return
+// Participants joined with their User and Event.
+type ParticipantStore interface {
+	ListUserParticipants(userId string, page Page) ([]Participant, error)
+	ListEventParticipants(eventId string, page Page) ([]Participant, error)
+	FindParticipant(id string) (ParticipantWrite, error)
+	FindEventParticipant(eventId, userId string) (ParticipantWrite, error)
+	// CreateParticipant fails with ErrDuplicate if the id is taken, see participantId
+	CreateParticipant(participant ParticipantWrite) (ParticipantWrite, error)
+	// ListEventWaitlist returns an event's waitlisted participants, longest
+	// waiting first: by updated_at, then id
+	ListEventWaitlist(eventId string, page Page) ([]Participant, error)
+	// TransitionParticipant moves the participant to t.To and records t,
+	// provided it is still in state t.From. Otherwise ErrConflict.
+	TransitionParticipant(id string, t ParticipantTransition) (ParticipantWrite, error)
+	DeleteParticipant(id string) error
+	DeleteEventParticipants(eventId string) error
+}
+
+// SessionStore persists UserSessions. A user has one session per device.
+type SessionStore interface {
+	FindSession(id string) (UserSession, error)
+	ListUserSessions(userId string) ([]UserSession, error)
+	CreateSession(session UserSession) (UserSession, error)
+	UpdateSession(session UserSession) error
+	DeleteSession(id string) error
+	DeleteUserSessions(userId string) error
+}
+
+// InviteLinkStore persists InviteLinks. CreateInviteLink generates the token.
+type InviteLinkStore interface {
+	FindInviteLink(token string) (InviteLink, error)
+	CreateInviteLink(link InviteLink) (InviteLink, error)
+	DeleteInviteLink(token string) error
+	DeleteEventInviteLinks(eventId string) error
+	DeleteUserInviteLinks(userId string) error
+}
+
+// SeatStore counts the accepted participants of each event, so that
+// capacity is enforced atomically across concurrent accepts
+type SeatStore interface {
+	// ReserveSeat takes one of the event's seats and reports whether it
+	// could, which it can't once capacity seats are taken. A capacity of 0
+	// is unlimited.
+	ReserveSeat(eventId string, capacity int) (bool, error)
+	ReleaseSeat(eventId string) error
+	DeleteEventSeats(eventId string) error
+}
+
+// NotificationStore persists Notifications. ListUserNotifications returns
+// up to limit notifications with an Id after afterId, oldest first.
+// WatchUserNotifications streams new notifications until stop is closed.
+type NotificationStore interface {
+	CreateNotification(n Notification) (Notification, error)
+	ListUserNotifications(userId, afterId string, limit int) ([]Notification, error)
+	WatchUserNotifications(userId string, stop <-chan struct{}) (<-chan Notification, error)
+	DeleteUserNotifications(userId string) error
+	DeleteNotificationsBefore(t time.Time) error
+}
+
+// ModerationStore persists the mutes and moderation log of events.
+// ListEventModeration returns the log newest first, so its cursors run
+// backwards: after is older.
+type ModerationStore interface {
+	FindMute(eventId, userId string) (Mute, error)
+	ListEventMutes(eventId string, page Page) ([]Mute, error)
+	// CreateMute fails with ErrDuplicate if the user is already muted
+	CreateMute(mute Mute) (Mute, error)
+	DeleteMute(eventId, userId string) error
+	DeleteUserMutes(userId string) error
+	// DeleteEventModeration deletes the event's mutes and moderation log
+	DeleteEventModeration(eventId string) error
+	CreateModerationAction(action ModerationAction) (ModerationAction, error)
+	ListEventModeration(eventId string, page Page) ([]ModerationAction, error)
+}
+
+// Store is everything the handlers need from a backend
+type Store interface {
+	UserStore
+	EventStore
+	MessageStore
+	ParticipantStore
+	SeatStore
+	InviteLinkStore
+	SessionStore
+	NotificationStore
+	ModerationStore
+	Close() error
+}
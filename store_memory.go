@@ -0,0 +1,1074 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a thread-safe in-process Store for tests and local development.
+// Nothing is persisted between runs.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	users        map[string]User
+	events       map[string]Event
+	messages     map[string]Message
+	participants map[string]ParticipantWrite
+	sessions     map[string]UserSession
+	inviteLinks  map[string]InviteLink
+	// seats taken per event
+	seats map[string]int
+	// notifications per user, oldest first
+	notifications map[string][]Notification
+	// earlier contents per message, oldest first
+	revisions map[string][]MessageRevision
+	mutes     map[string]Mute
+	// moderation log per event
+	moderation map[string][]ModerationAction
+
+	// secondary indexes, mirroring the ones the RethinkDB backend queries
+	usersByFacebookId map[string]string
+	sessionsByUser    map[string]map[string]bool
+
+	// hub stands in for RethinkDB changefeeds
+	hub *Hub
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:             make(map[string]User),
+		events:            make(map[string]Event),
+		messages:          make(map[string]Message),
+		participants:      make(map[string]ParticipantWrite),
+		sessions:          make(map[string]UserSession),
+		inviteLinks:       make(map[string]InviteLink),
+		seats:             make(map[string]int),
+		notifications:     make(map[string][]Notification),
+		revisions:         make(map[string][]MessageRevision),
+		mutes:             make(map[string]Mute),
+		moderation:        make(map[string][]ModerationAction),
+		usersByFacebookId: make(map[string]string),
+		sessionsByUser:    make(map[string]map[string]bool),
+		hub:               NewHub(),
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+//// Helpers
+
+// newId returns a random (version 4) UUID, the same shape RethinkDB generates
+func newId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// nameId returns a name based (version 5 style) UUID, always the same for
+// the same name. Records that must be unique per name use it as their id, so
+// a second insert fails on the primary key.
+func nameId(name string) string {
+	b := sha1.Sum([]byte(name))
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// pageBounds returns the [lo, hi) range of page within n sorted records.
+// pos compares record i with a cursor: negative if the record comes first in
+// the list, positive if it comes after it. It's only needed for cursor pages.
+func pageBounds(n int, page Page, pos func(i int, c Cursor) int) (lo, hi int) {
+	if page.Per < 1 {
+		return 0, 0
+	}
+	switch {
+	case page.After != nil:
+		lo = sort.Search(n, func(i int) bool { return pos(i, *page.After) > 0 })
+		hi = lo + page.Per
+	case page.Before != nil:
+		hi = sort.Search(n, func(i int) bool { return pos(i, *page.Before) >= 0 })
+		lo = hi - page.Per
+	case page.Number < 1:
+		return 0, 0
+	default:
+		lo, hi = (page.Number-1)*page.Per, page.Number*page.Per
+	}
+	if lo < 0 {
+		lo = 0
+	}
+	if lo > n {
+		lo = n
+	}
+	if hi > n {
+		hi = n
+	}
+	return lo, hi
+}
+
+// ascending is the pos of pageBounds for records sorted by their cursors
+func ascending(cursor func(i int) Cursor) func(i int, c Cursor) int {
+	return func(i int, c Cursor) int { return compareCursors(cursor(i), c) }
+}
+
+//// Users
+
+func (s *MemoryStore) ListUsers(page Page) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].Id < users[j].Id
+		}
+		return users[i].CreatedAt.Before(users[j].CreatedAt)
+	})
+
+	lo, hi := pageBounds(len(users), page, ascending(func(i int) Cursor { return Cursor{users[i].CreatedAt, users[i].Id} }))
+	return users[lo:hi], nil
+}
+
+func (s *MemoryStore) FindUser(id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *MemoryStore) FindUserByFacebookId(facebookId string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.usersByFacebookId[facebookId]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return s.users[id], nil
+}
+
+func (s *MemoryStore) FindUserByEmail(email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryStore) CreateUser(user User) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user.Id == "" {
+		user.Id = newId()
+	}
+	if _, ok := s.users[user.Id]; ok {
+		return User{}, ErrDuplicate
+	}
+	s.users[user.Id] = user
+	if user.FacebookId != "" {
+		s.usersByFacebookId[user.FacebookId] = user.Id
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) UpdateUser(user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.users[user.Id]
+	if !ok {
+		return nil
+	}
+	if old.FacebookId != user.FacebookId {
+		delete(s.usersByFacebookId, old.FacebookId)
+		if user.FacebookId != "" {
+			s.usersByFacebookId[user.FacebookId] = user.Id
+		}
+	}
+	s.users[user.Id] = user
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[id]; ok {
+		delete(s.usersByFacebookId, u.FacebookId)
+		delete(s.users, id)
+	}
+	return nil
+}
+
+//// Events
+
+func (s *MemoryStore) listEvents(match func(Event) bool, page Page) []Event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []Event{}
+	for _, e := range s.events {
+		if match(e) {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].Id < events[j].Id
+		}
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+
+	lo, hi := pageBounds(len(events), page, ascending(func(i int) Cursor { return Cursor{events[i].CreatedAt, events[i].Id} }))
+	return events[lo:hi]
+}
+
+func (s *MemoryStore) ListEvents(privacyLevels []int, page Page) ([]Event, error) {
+	return s.listEvents(func(e Event) bool { return hasLevel(privacyLevels, e.PrivacyLevel) }, page), nil
+}
+
+// ListNearbyEvents scans every event, there's no geo index in memory
+func (s *MemoryStore) ListNearbyEvents(lat, lon, radiusKm float64, privacyLevels []int, page, per int) ([]NearbyEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := []NearbyEvent{}
+	for _, e := range s.events {
+		if len(e.Location) != 2 || !hasLevel(privacyLevels, e.PrivacyLevel) {
+			continue
+		}
+		d := haversineKm(lat, lon, e.Location[1], e.Location[0])
+		if d <= radiusKm {
+			events = append(events, NearbyEvent{Event: e, DistanceKm: d})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].DistanceKm == events[j].DistanceKm {
+			return events[i].Id < events[j].Id
+		}
+		return events[i].DistanceKm < events[j].DistanceKm
+	})
+
+	lo, hi := pageBounds(len(events), Page{Number: page, Per: per}, nil)
+	return events[lo:hi], nil
+}
+
+func (s *MemoryStore) ListEventsWithin(areas []Polygon, privacyLevels []int, page Page) ([]Event, error) {
+	return s.listEvents(func(e Event) bool {
+		if len(e.Location) != 2 || !hasLevel(privacyLevels, e.PrivacyLevel) {
+			return false
+		}
+		for _, area := range areas {
+			if area.contains(e.Location[0], e.Location[1]) {
+				return true
+			}
+		}
+		return false
+	}, page), nil
+}
+
+func hasLevel(levels []int, level int) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) ListUserEvents(userId string, page Page) ([]Event, error) {
+	return s.listEvents(func(e Event) bool { return e.UserId == userId }, page), nil
+}
+
+func (s *MemoryStore) FindEvent(id string) (Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.events[id]
+	if !ok {
+		return Event{}, ErrNotFound
+	}
+	return e, nil
+}
+
+func (s *MemoryStore) CreateEvent(event Event) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.Id == "" {
+		event.Id = newId()
+	}
+	if _, ok := s.events[event.Id]; ok {
+		return Event{}, ErrDuplicate
+	}
+	s.events[event.Id] = event
+	return event, nil
+}
+
+func (s *MemoryStore) UpdateEvent(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.events[event.Id]; ok {
+		s.events[event.Id] = event
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteEvent(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.events, id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserEvents(userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, e := range s.events {
+		if e.UserId == userId {
+			delete(s.events, id)
+		}
+	}
+	return nil
+}
+
+//// Messages
+
+func (s *MemoryStore) listMessages(match func(Message) bool, page Page) []Message {
+	messages := s.matchMessages(match)
+	lo, hi := pageBounds(len(messages), page, ascending(func(i int) Cursor { return Cursor{messages[i].CreatedAt, messages[i].Id} }))
+	return messages[lo:hi]
+}
+
+// matchMessages returns all matching messages, oldest first
+func (s *MemoryStore) matchMessages(match func(Message) bool) []Message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := []Message{}
+	for _, m := range s.messages {
+		if match(m) {
+			messages = append(messages, m)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].Id < messages[j].Id
+		}
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+	return messages
+}
+
+func (s *MemoryStore) ListUserMessages(userId string, page Page) ([]Message, error) {
+	return s.listMessages(func(m Message) bool { return m.UserId == userId }, page), nil
+}
+
+func (s *MemoryStore) ListEventMessages(eventId string, page Page) ([]Message, error) {
+	return s.listMessages(func(m Message) bool { return m.EventId == eventId }, page), nil
+}
+
+func (s *MemoryStore) ListEventThreads(eventId string, page Page) ([]Message, error) {
+	return s.listMessages(func(m Message) bool { return m.EventId == eventId && m.References == "" }, page), nil
+}
+
+func (s *MemoryStore) ListMessageReplies(id string, page Page) ([]Message, error) {
+	return s.listMessages(func(m Message) bool { return m.References == id }, page), nil
+}
+
+func (s *MemoryStore) ListRepliesTo(ids []string) ([]Message, error) {
+	parents := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		parents[id] = true
+	}
+	return s.matchMessages(func(m Message) bool { return parents[m.References] }), nil
+}
+
+func (s *MemoryStore) CountReplies(ids []string) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int, len(ids))
+	for _, id := range ids {
+		counts[id] = 0
+	}
+	for _, m := range s.messages {
+		if _, ok := counts[m.References]; ok {
+			counts[m.References]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *MemoryStore) FindMessage(id string) (Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.messages[id]
+	if !ok {
+		return Message{}, ErrNotFound
+	}
+	return m, nil
+}
+
+func (s *MemoryStore) CreateMessage(message Message) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message.Id == "" {
+		message.Id = newId()
+	}
+	if _, ok := s.messages[message.Id]; ok {
+		return Message{}, ErrDuplicate
+	}
+	s.messages[message.Id] = message
+	s.publishMessage(MessageCreated, message)
+	return message, nil
+}
+
+func (s *MemoryStore) UpdateMessage(message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[message.Id]; ok {
+		s.messages[message.Id] = message
+		s.publishMessage(MessageUpdated, message)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReviseMessage(message Message, revision MessageRevision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.messages[message.Id]
+	if !ok {
+		return nil
+	}
+	if revision.Id == "" {
+		revision.Id = newId()
+	}
+	s.revisions[message.Id] = append(s.revisions[message.Id], revision)
+	s.messages[message.Id] = message
+	if message.DeletedAt != nil && old.DeletedAt == nil {
+		s.publishMessage(MessageDeleted, message)
+	} else {
+		s.publishMessage(MessageUpdated, message)
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListMessageRevisions(id string) ([]MessageRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]MessageRevision{}, s.revisions[id]...), nil
+}
+
+func (s *MemoryStore) SetMessageFlag(id, flag string, at *time.Time) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.messages[id]
+	if !ok {
+		return Message{}, ErrNotFound
+	}
+	switch flag {
+	case FlagHidden:
+		m.HiddenAt = at
+	case FlagPinned:
+		m.PinnedAt = at
+	default:
+		return Message{}, fmt.Errorf("unknown message flag %q", flag)
+	}
+	s.messages[id] = m
+	s.publishMessage(MessageUpdated, m)
+	return m, nil
+}
+
+func (s *MemoryStore) ListEventPins(eventId string) ([]Message, error) {
+	pins := s.matchMessages(func(m Message) bool { return m.EventId == eventId && m.PinnedAt != nil })
+	sort.SliceStable(pins, func(i, j int) bool { return pins[i].PinnedAt.After(*pins[j].PinnedAt) })
+	return pins, nil
+}
+
+func (s *MemoryStore) DeleteMessage(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m, ok := s.messages[id]; ok {
+		delete(s.messages, id)
+		delete(s.revisions, id)
+		s.publishMessage(MessageDeleted, m)
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserMessages(userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, m := range s.messages {
+		if m.UserId == userId {
+			delete(s.messages, id)
+			delete(s.revisions, id)
+			s.publishMessage(MessageDeleted, m)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteEventMessages(eventId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, m := range s.messages {
+		if m.EventId == eventId {
+			delete(s.messages, id)
+			delete(s.revisions, id)
+			s.publishMessage(MessageDeleted, m)
+		}
+	}
+	return nil
+}
+
+// WatchEventMessages relays the event's message changes from the hub
+func (s *MemoryStore) WatchEventMessages(eventId string, stop <-chan struct{}) (<-chan MessageChange, error) {
+	topic := "messages:" + eventId
+	sub := s.hub.Subscribe(topic, 64)
+	changes := make(chan MessageChange)
+	go func() {
+		defer close(changes)
+		defer s.hub.Unsubscribe(topic, sub)
+		for {
+			select {
+			case <-stop:
+				return
+			case v, ok := <-sub:
+				if !ok {
+					return
+				}
+				select {
+				case changes <- v.(MessageChange):
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// publishMessage tells watchers of the message's event about a change. Call with s.mu held.
+func (s *MemoryStore) publishMessage(typ string, m Message) {
+	s.hub.Publish("messages:"+m.EventId, MessageChange{Type: typ, Message: m})
+}
+
+//// Participants
+
+// participantCreated is the default participant order, oldest first
+func participantCreated(p ParticipantWrite) Cursor {
+	return Cursor{p.CreatedAt, p.Id}
+}
+
+// listParticipants returns matching participants ordered by their key,
+// joined with their User and Event
+func (s *MemoryStore) listParticipants(match func(ParticipantWrite) bool, key func(ParticipantWrite) Cursor, page Page) []Participant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := []ParticipantWrite{}
+	for _, p := range s.participants {
+		if match(p) {
+			rows = append(rows, p)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return compareCursors(key(rows[i]), key(rows[j])) < 0 })
+
+	lo, hi := pageBounds(len(rows), page, ascending(func(i int) Cursor { return key(rows[i]) }))
+	participants := make([]Participant, 0, hi-lo)
+	for _, p := range rows[lo:hi] {
+		participants = append(participants, Participant{
+			Id:             p.Id,
+			EventId:        p.EventId,
+			Event:          s.events[p.EventId],
+			UserId:         p.UserId,
+			User:           s.users[p.UserId],
+			Status:         p.Status,
+			RequestStatus:  p.RequestStatus,
+			ResponseStatus: p.ResponseStatus,
+			Transitions:    p.Transitions,
+			CreatedAt:      p.CreatedAt,
+			UpdatedAt:      p.UpdatedAt,
+		})
+	}
+	return participants
+}
+
+func (s *MemoryStore) ListEventWaitlist(eventId string, page Page) ([]Participant, error) {
+	// Waitlisted participants don't change until they leave the waitlist,
+	// so they've waited since UpdatedAt
+	waited := func(p ParticipantWrite) Cursor { return Cursor{p.UpdatedAt, p.Id} }
+	return s.listParticipants(func(p ParticipantWrite) bool {
+		return p.EventId == eventId && p.Status == ParticipantWaitlisted
+	}, waited, page), nil
+}
+
+func (s *MemoryStore) ListUserParticipants(userId string, page Page) ([]Participant, error) {
+	return s.listParticipants(func(p ParticipantWrite) bool { return p.UserId == userId }, participantCreated, page), nil
+}
+
+func (s *MemoryStore) ListEventParticipants(eventId string, page Page) ([]Participant, error) {
+	return s.listParticipants(func(p ParticipantWrite) bool { return p.EventId == eventId }, participantCreated, page), nil
+}
+
+func (s *MemoryStore) FindParticipant(id string) (ParticipantWrite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.participants[id]
+	if !ok {
+		return ParticipantWrite{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *MemoryStore) FindEventParticipant(eventId, userId string) (ParticipantWrite, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, p := range s.participants {
+		if p.EventId == eventId && p.UserId == userId {
+			return p, nil
+		}
+	}
+	return ParticipantWrite{}, ErrNotFound
+}
+
+func (s *MemoryStore) CreateParticipant(participant ParticipantWrite) (ParticipantWrite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if participant.Id == "" {
+		participant.Id = newId()
+	}
+	if _, ok := s.participants[participant.Id]; ok {
+		return ParticipantWrite{}, ErrDuplicate
+	}
+	s.participants[participant.Id] = participant
+	return participant, nil
+}
+
+func (s *MemoryStore) TransitionParticipant(id string, t ParticipantTransition) (ParticipantWrite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.participants[id]
+	if !ok {
+		return ParticipantWrite{}, ErrNotFound
+	}
+	if p.Status != t.From {
+		return ParticipantWrite{}, ErrConflict
+	}
+	// Copy the history, readers may still hold the old slice
+	p.Transitions = append([]ParticipantTransition{}, p.Transitions...)
+	applyParticipantTransition(&p, t)
+	s.participants[id] = p
+	return p, nil
+}
+
+func (s *MemoryStore) DeleteParticipant(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.participants, id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteEventParticipants(eventId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, p := range s.participants {
+		if p.EventId == eventId {
+			delete(s.participants, id)
+		}
+	}
+	return nil
+}
+
+//// Invite links
+
+func (s *MemoryStore) FindInviteLink(token string) (InviteLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, ok := s.inviteLinks[token]
+	if !ok {
+		return InviteLink{}, ErrNotFound
+	}
+	return link, nil
+}
+
+func (s *MemoryStore) CreateInviteLink(link InviteLink) (InviteLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if link.Id == "" {
+		link.Id = newId()
+	}
+	if _, ok := s.inviteLinks[link.Id]; ok {
+		return InviteLink{}, ErrDuplicate
+	}
+	s.inviteLinks[link.Id] = link
+	return link, nil
+}
+
+func (s *MemoryStore) DeleteInviteLink(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inviteLinks, token)
+	return nil
+}
+
+func (s *MemoryStore) DeleteEventInviteLinks(eventId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, link := range s.inviteLinks {
+		if link.EventId == eventId {
+			delete(s.inviteLinks, token)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserInviteLinks(userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, link := range s.inviteLinks {
+		if link.UserId == userId {
+			delete(s.inviteLinks, token)
+		}
+	}
+	return nil
+}
+
+//// Seats
+
+func (s *MemoryStore) ReserveSeat(eventId string, capacity int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if capacity > 0 && s.seats[eventId] >= capacity {
+		return false, nil
+	}
+	s.seats[eventId]++
+	return true, nil
+}
+
+func (s *MemoryStore) ReleaseSeat(eventId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seats[eventId] > 0 {
+		s.seats[eventId]--
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteEventSeats(eventId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.seats, eventId)
+	return nil
+}
+
+//// Sessions
+
+func (s *MemoryStore) FindSession(id string) (UserSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	us, ok := s.sessions[id]
+	if !ok {
+		return UserSession{}, ErrNotFound
+	}
+	return us, nil
+}
+
+func (s *MemoryStore) ListUserSessions(userId string) ([]UserSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := []UserSession{}
+	for id := range s.sessionsByUser[userId] {
+		sessions = append(sessions, s.sessions[id])
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (s *MemoryStore) CreateSession(session UserSession) (UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session.Id == "" {
+		session.Id = newId()
+	}
+	if _, ok := s.sessions[session.Id]; ok {
+		return UserSession{}, ErrDuplicate
+	}
+	s.sessions[session.Id] = session
+	if s.sessionsByUser[session.UserId] == nil {
+		s.sessionsByUser[session.UserId] = make(map[string]bool)
+	}
+	s.sessionsByUser[session.UserId][session.Id] = true
+	return session, nil
+}
+
+func (s *MemoryStore) UpdateSession(session UserSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[session.Id]; ok {
+		s.sessions[session.Id] = session
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if us, ok := s.sessions[id]; ok {
+		delete(s.sessionsByUser[us.UserId], id)
+		delete(s.sessions, id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserSessions(userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.sessionsByUser[userId] {
+		delete(s.sessions, id)
+	}
+	delete(s.sessionsByUser, userId)
+	return nil
+}
+
+//// Notifications
+
+func (s *MemoryStore) CreateNotification(n Notification) (Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n.Id == "" {
+		n.Id = newNotificationId(n.CreatedAt)
+	}
+	// Keep each user's list sorted, ids are nearly always increasing
+	list := append(s.notifications[n.UserId], n)
+	for i := len(list) - 1; i > 0 && list[i].Id < list[i-1].Id; i-- {
+		list[i], list[i-1] = list[i-1], list[i]
+	}
+	s.notifications[n.UserId] = list
+	s.hub.Publish("notifications:"+n.UserId, n)
+	return n, nil
+}
+
+func (s *MemoryStore) ListUserNotifications(userId, afterId string, limit int) ([]Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := s.notifications[userId]
+	i := sort.Search(len(list), func(i int) bool { return list[i].Id > afterId })
+	list = list[i:]
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return append([]Notification{}, list...), nil
+}
+
+func (s *MemoryStore) WatchUserNotifications(userId string, stop <-chan struct{}) (<-chan Notification, error) {
+	topic := "notifications:" + userId
+	sub := s.hub.Subscribe(topic, 64)
+	notifications := make(chan Notification)
+	go func() {
+		defer close(notifications)
+		defer s.hub.Unsubscribe(topic, sub)
+		for {
+			select {
+			case <-stop:
+				return
+			case v, ok := <-sub:
+				if !ok {
+					return
+				}
+				select {
+				case notifications <- v.(Notification):
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return notifications, nil
+}
+
+func (s *MemoryStore) DeleteUserNotifications(userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.notifications, userId)
+	return nil
+}
+
+func (s *MemoryStore) DeleteNotificationsBefore(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for userId, list := range s.notifications {
+		kept := list[:0]
+		for _, n := range list {
+			if !n.CreatedAt.Before(t) {
+				kept = append(kept, n)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.notifications, userId)
+		} else {
+			s.notifications[userId] = kept
+		}
+	}
+	return nil
+}
+
+//// Moderation
+
+func (s *MemoryStore) FindMute(eventId, userId string) (Mute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mute, ok := s.mutes[muteId(eventId, userId)]
+	if !ok {
+		return Mute{}, ErrNotFound
+	}
+	return mute, nil
+}
+
+func (s *MemoryStore) ListEventMutes(eventId string, page Page) ([]Mute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mutes := []Mute{}
+	for _, mute := range s.mutes {
+		if mute.EventId == eventId {
+			mutes = append(mutes, mute)
+		}
+	}
+	sort.Slice(mutes, func(i, j int) bool {
+		if mutes[i].CreatedAt.Equal(mutes[j].CreatedAt) {
+			return mutes[i].Id < mutes[j].Id
+		}
+		return mutes[i].CreatedAt.Before(mutes[j].CreatedAt)
+	})
+	lo, hi := pageBounds(len(mutes), page, ascending(func(i int) Cursor { return Cursor{mutes[i].CreatedAt, mutes[i].Id} }))
+	return mutes[lo:hi], nil
+}
+
+func (s *MemoryStore) CreateMute(mute Mute) (Mute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mute.Id = muteId(mute.EventId, mute.UserId)
+	if _, ok := s.mutes[mute.Id]; ok {
+		return Mute{}, ErrDuplicate
+	}
+	s.mutes[mute.Id] = mute
+	return mute, nil
+}
+
+func (s *MemoryStore) DeleteMute(eventId, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.mutes, muteId(eventId, userId))
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserMutes(userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, mute := range s.mutes {
+		if mute.UserId == userId {
+			delete(s.mutes, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteEventModeration(eventId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, mute := range s.mutes {
+		if mute.EventId == eventId {
+			delete(s.mutes, id)
+		}
+	}
+	delete(s.moderation, eventId)
+	return nil
+}
+
+func (s *MemoryStore) CreateModerationAction(action ModerationAction) (ModerationAction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if action.Id == "" {
+		action.Id = newId()
+	}
+	s.moderation[action.EventId] = append(s.moderation[action.EventId], action)
+	return action, nil
+}
+
+func (s *MemoryStore) ListEventModeration(eventId string, page Page) ([]ModerationAction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	newest := append([]ModerationAction{}, s.moderation[eventId]...)
+	cursor := func(i int) Cursor { return Cursor{newest[i].CreatedAt, newest[i].Id} }
+	sort.Slice(newest, func(i, j int) bool { return compareCursors(cursor(i), cursor(j)) > 0 })
+	lo, hi := pageBounds(len(newest), page, func(i int, c Cursor) int { return -compareCursors(cursor(i), c) })
+	return newest[lo:hi], nil
+}
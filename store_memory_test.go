@@ -0,0 +1,108 @@
+package main
+
+import (
+  "sync"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreUsers(t *testing.T) {
+  s := NewMemoryStore()
+
+  u, err := s.CreateUser(User{FirstName: "Arya", FacebookId: "fb-arya"})
+  assert.Nil(t, err)
+  assert.NotEmpty(t, u.Id)
+
+  found, err := s.FindUserByFacebookId("fb-arya")
+  assert.Nil(t, err)
+  assert.Equal(t, u.Id, found.Id)
+
+  _, err = s.FindUser("nope")
+  assert.Equal(t, ErrNotFound, err)
+
+  assert.Nil(t, s.DeleteUser(u.Id))
+  _, err = s.FindUserByFacebookId("fb-arya")
+  assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryStorePagination(t *testing.T) {
+  s := NewMemoryStore()
+  base := time.Date(2015, time.March, 1, 0, 0, 0, 0, time.UTC)
+  for i := 4; i >= 0; i-- {
+    s.CreateEvent(Event{UserId: "u1", Title: string(rune('a' + i)), CreatedAt: base.Add(time.Duration(i) * time.Hour)})
+  }
+  s.CreateEvent(Event{UserId: "u2", Title: "other", CreatedAt: base})
+
+  page, _ := s.ListUserEvents("u1", Page{Number: 1, Per: 2})
+  assert.Equal(t, 2, len(page))
+  assert.Equal(t, "a", page[0].Title)
+  assert.Equal(t, "b", page[1].Title)
+
+  page3, _ := s.ListUserEvents("u1", Page{Number: 3, Per: 2})
+  assert.Equal(t, 1, len(page3))
+  assert.Equal(t, "e", page3[0].Title)
+
+  page, _ = s.ListUserEvents("u1", Page{Number: 4, Per: 2})
+  assert.Equal(t, 0, len(page))
+
+  // cursors page from a position, ignoring the page number
+  after := Cursor{page3[0].CreatedAt, page3[0].Id}
+  page, _ = s.ListUserEvents("u1", Page{Number: 9, Per: 2, After: &after})
+  assert.Equal(t, 0, len(page))
+  page, _ = s.ListUserEvents("u1", Page{Number: 9, Per: 2, Before: &after})
+  assert.Equal(t, 2, len(page))
+  assert.Equal(t, "c", page[0].Title)
+  assert.Equal(t, "d", page[1].Title)
+  after = Cursor{page[0].CreatedAt, page[0].Id}
+  page, _ = s.ListUserEvents("u1", Page{Per: 2, After: &after})
+  assert.Equal(t, 2, len(page))
+  assert.Equal(t, "d", page[0].Title)
+  assert.Equal(t, "e", page[1].Title)
+
+  assert.Nil(t, s.DeleteUserEvents("u1"))
+  all, _ := s.ListEvents([]int{PrivacyPublic}, Page{Number: 1, Per: 20})
+  assert.Equal(t, 1, len(all))
+}
+
+func TestMemoryStoreParticipantsJoin(t *testing.T) {
+  s := NewMemoryStore()
+  u, _ := s.CreateUser(User{FirstName: "Sansa"})
+  e, _ := s.CreateEvent(Event{UserId: u.Id, Title: "Wedding"})
+  s.CreateParticipant(ParticipantWrite{UserId: u.Id, EventId: e.Id, Status: ParticipantRequested})
+
+  participants, err := s.ListEventParticipants(e.Id, Page{Number: 1, Per: 20})
+  assert.Nil(t, err)
+  assert.Equal(t, 1, len(participants))
+  assert.Equal(t, "Sansa", participants[0].User.FirstName)
+  assert.Equal(t, "Wedding", participants[0].Event.Title)
+}
+
+func TestMemoryStoreSessionsConcurrent(t *testing.T) {
+  s := NewMemoryStore()
+  var wg sync.WaitGroup
+  for i := 0; i < 50; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      us, err := s.CreateSession(UserSession{UserId: "u1"})
+      assert.Nil(t, err)
+      _, err = s.FindSession(us.Id)
+      assert.Nil(t, err)
+    }()
+  }
+  wg.Wait()
+
+  sessions, err := s.ListUserSessions("u1")
+  assert.Nil(t, err)
+  assert.Equal(t, 50, len(sessions))
+
+  assert.Nil(t, s.DeleteSession(sessions[0].Id))
+  _, err = s.FindSession(sessions[0].Id)
+  assert.Equal(t, ErrNotFound, err)
+
+  assert.Nil(t, s.DeleteUserSessions("u1"))
+  sessions, _ = s.ListUserSessions("u1")
+  assert.Equal(t, 0, len(sessions))
+}
@@ -0,0 +1,834 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	r "github.com/dancannon/gorethink"
+	"github.com/dancannon/gorethink/encoding"
+	"github.com/dancannon/gorethink/types"
+)
+
+// RethinkStore is the RethinkDB backed Store
+type RethinkStore struct {
+	session  *r.Session
+	database string
+}
+
+func NewRethinkStore(opts r.ConnectOpts) (*RethinkStore, error) {
+	session, err := r.Connect(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &RethinkStore{session: session, database: opts.Database}, nil
+}
+
+// MarshalRQL stores a Location as a geometry point so it can be geo indexed
+func (l Location) MarshalRQL() (interface{}, error) {
+	if len(l) != 2 {
+		return nil, nil
+	}
+	return types.Point{Lon: l[0], Lat: l[1]}.MarshalRQL()
+}
+
+// UnmarshalRQL reads geometry points as well as the [lon, lat] arrays
+// written before migration 3
+func (l *Location) UnmarshalRQL(data interface{}) error {
+	if data == nil {
+		*l = nil
+		return nil
+	}
+	if arr, ok := data.([]interface{}); ok {
+		if len(arr) != 2 {
+			return errors.New("location must be a [lon, lat] pair")
+		}
+		lon, ok1 := arr[0].(float64)
+		lat, ok2 := arr[1].(float64)
+		if !ok1 || !ok2 {
+			return errors.New("location must be a [lon, lat] pair")
+		}
+		*l = Location{lon, lat}
+		return nil
+	}
+
+	var p types.Point
+	if err := p.UnmarshalRQL(data); err != nil {
+		return err
+	}
+	*l = Location{p.Lon, p.Lat}
+	return nil
+}
+
+func (s *RethinkStore) Close() error {
+	return s.session.Close()
+}
+
+//// Helpers
+
+// get loads the document with the given primary key into v
+func (s *RethinkStore) get(table, id string, v interface{}) error {
+	res, err := r.Table(table).Get(id).Run(s.session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	if res.IsNil() {
+		return ErrNotFound
+	}
+	return res.One(v)
+}
+
+// first loads the first result of a query into v
+func (s *RethinkStore) first(term r.Term, v interface{}) error {
+	res, err := term.Run(s.session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	if res.IsNil() {
+		return ErrNotFound
+	}
+	return res.One(v)
+}
+
+// all loads every result of a query into v
+func (s *RethinkStore) all(term r.Term, v interface{}) error {
+	res, err := term.Run(s.session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	return res.All(v)
+}
+
+// insert writes doc to table and decodes the stored document into v
+func (s *RethinkStore) insert(table string, doc interface{}, v interface{}) error {
+	res, err := r.Table(table).Insert(doc, r.InsertOpts{ReturnChanges: true}).RunWrite(s.session)
+	if err != nil {
+		return err
+	}
+	if len(res.Changes) == 0 {
+		if strings.HasPrefix(res.FirstError, "Duplicate primary key") {
+			return ErrDuplicate
+		}
+		return errors.New("insert into " + table + " returned no changes: " + res.FirstError)
+	}
+
+	return encoding.Decode(v, res.Changes[0].NewValue) // using reflection
+}
+
+func (s *RethinkStore) update(table, id string, doc interface{}) error {
+	_, err := r.Table(table).Get(id).Update(doc).RunWrite(s.session)
+	return err
+}
+
+func (s *RethinkStore) delete(table, id string) error {
+	_, err := r.Table(table).Get(id).Delete().RunWrite(s.session)
+	return err
+}
+
+// stringArgs converts ids for variadic ReQL terms like GetAll
+func stringArgs(ids []string) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// pageRange reads table through index, a compound index of scope's values
+// followed by the time and id its list is ordered by (see migration 11),
+// from page's cursor on. Lists are ordered oldest first, or newest first if
+// desc. Pages before a cursor are read backwards from it; pageLimit then
+// cuts the page out and puts it back in order, after any filters.
+func pageRange(table, index string, scope []interface{}, page Page, desc bool) r.Term {
+	key := func(at, id interface{}) []interface{} {
+		return append(append([]interface{}{}, scope...), at, id)
+	}
+	lower, upper := key(r.MinVal, r.MinVal), key(r.MaxVal, r.MaxVal)
+	opts := r.BetweenOpts{Index: index}
+	forward := page.Before == nil
+	switch {
+	case page.After == nil && page.Before == nil:
+	case forward != desc:
+		c := pageCursor(page)
+		lower, opts.LeftBound = key(c.At, c.Id), "open"
+	default:
+		c := pageCursor(page)
+		upper = key(c.At, c.Id)
+	}
+
+	order := r.Asc(index)
+	if forward == desc {
+		order = r.Desc(index)
+	}
+	return r.Table(table).Between(lower, upper, opts).OrderBy(r.OrderByOpts{Index: order})
+}
+
+// pageFilter is pageRange for selections that can't be read through an
+// index. They're ordered by timeField and id in memory, oldest first.
+func pageFilter(term r.Term, page Page, timeField string) r.Term {
+	key := func(row r.Term) r.Term {
+		return r.Expr([]interface{}{row.Field(timeField), row.Field("id")})
+	}
+	switch {
+	case page.After != nil:
+		return term.Filter(func(row r.Term) r.Term {
+			return key(row).Gt([]interface{}{page.After.At, page.After.Id})
+		}).OrderBy(r.Asc(timeField), r.Asc("id"))
+	case page.Before != nil:
+		return term.Filter(func(row r.Term) r.Term {
+			return key(row).Lt([]interface{}{page.Before.At, page.Before.Id})
+		}).OrderBy(r.Desc(timeField), r.Desc("id"))
+	}
+	return term.OrderBy(r.Asc(timeField), r.Asc("id"))
+}
+
+// pageLimit cuts page out of a list read by pageRange or pageFilter
+func pageLimit(term r.Term, page Page, timeField string, desc bool) r.Term {
+	if page.After == nil && page.Before == nil {
+		return term.Slice((page.Number-1)*page.Per, page.Number*page.Per)
+	}
+	term = term.Limit(page.Per)
+	if page.Before == nil {
+		return term
+	}
+	if desc {
+		return term.OrderBy(r.Desc(timeField), r.Desc("id"))
+	}
+	return term.OrderBy(r.Asc(timeField), r.Asc("id"))
+}
+
+// pageCursor is the cursor page starts from, if any
+func pageCursor(page Page) *Cursor {
+	if page.After != nil {
+		return page.After
+	}
+	return page.Before
+}
+
+// withUserAndEvent joins the User and Event onto each participant
+func withUserAndEvent(term r.Term) r.Term {
+	return term.Merge(map[string]interface{}{
+		"User":  r.Table("users").Get(r.Row.Field("user_id")),
+		"Event": r.Table("events").Get(r.Row.Field("event_id")),
+	})
+}
+
+//// Users
+
+func (s *RethinkStore) ListUsers(page Page) (users []User, err error) {
+	users = []User{}
+	err = s.all(pageLimit(pageRange("users", "created_at", nil, page, false), page, "created_at", false), &users)
+	return users, err
+}
+
+func (s *RethinkStore) FindUser(id string) (u User, err error) {
+	err = s.get("users", id, &u)
+	return u, err
+}
+
+func (s *RethinkStore) FindUserByFacebookId(facebookId string) (u User, err error) {
+	err = s.first(r.Table("users").GetAllByIndex("facebook_id", facebookId), &u)
+	return u, err
+}
+
+func (s *RethinkStore) FindUserByEmail(email string) (u User, err error) {
+	err = s.first(r.Table("users").GetAllByIndex("email", email), &u)
+	return u, err
+}
+
+func (s *RethinkStore) CreateUser(user User) (u User, err error) {
+	err = s.insert("users", user, &u)
+	return u, err
+}
+
+func (s *RethinkStore) UpdateUser(user User) error {
+	return s.update("users", user.Id, user)
+}
+
+func (s *RethinkStore) DeleteUser(id string) error {
+	return s.delete("users", id)
+}
+
+//// Events
+
+func (s *RethinkStore) ListEvents(privacyLevels []int, page Page) (events []Event, err error) {
+	events = []Event{}
+	err = s.all(pageLimit(pageRange("events", "created_at", nil, page, false).Filter(func(e r.Term) r.Term {
+		return r.Expr(privacyLevels).Contains(e.Field("privacy_level"))
+	}), page, "created_at", false), &events)
+	return events, err
+}
+
+// nearbyLimit caps how many events getNearest considers for one search
+const nearbyLimit = 10000
+
+func (s *RethinkStore) ListNearbyEvents(lat, lon, radiusKm float64, privacyLevels []int, page, per int) (events []NearbyEvent, err error) {
+	events = []NearbyEvent{}
+	// getNearest returns {dist, doc} pairs, closest first
+	term := r.Table("events").GetNearest(r.Point(lon, lat), r.GetNearestOpts{
+		Index:      "location",
+		MaxDist:    radiusKm,
+		Unit:       "km",
+		MaxResults: nearbyLimit,
+	}).Filter(func(row r.Term) r.Term {
+		return r.Expr(privacyLevels).Contains(row.Field("doc").Field("privacy_level"))
+	}).Map(func(row r.Term) r.Term {
+		return row.Field("doc").Merge(map[string]interface{}{"distance_km": row.Field("dist")})
+	})
+	err = s.all(term.Slice((page-1)*per, page*per), &events)
+	return events, err
+}
+
+func (s *RethinkStore) ListEventsWithin(areas []Polygon, privacyLevels []int, page Page) (events []Event, err error) {
+	events = []Event{}
+	if len(areas) == 0 {
+		return events, nil
+	}
+
+	// RethinkDB's polygon edges are geodesics, densifying keeps them close
+	// to the straight lon/lat edges the memory store uses
+	var term r.Term
+	for i, area := range areas {
+		within := r.Table("events").GetIntersecting(
+			r.GeoJSON(map[string]interface{}{"type": "Polygon", "coordinates": area.densify(maxEdgeDegrees)}),
+			r.GetIntersectingOpts{Index: "location"},
+		)
+		if i == 0 {
+			term = within
+		} else {
+			term = term.Union(within)
+		}
+	}
+	if len(areas) > 1 {
+		// An event on the edge two areas share, like the antimeridian
+		// between the halves of a bbox, is in both
+		term = term.Group("id").Nth(0).Ungroup().Map(func(g r.Term) interface{} {
+			return g.Field("reduction")
+		})
+	}
+	term = term.Filter(func(e r.Term) r.Term {
+		return r.Expr(privacyLevels).Contains(e.Field("privacy_level"))
+	})
+	err = s.all(pageLimit(pageFilter(term, page, "created_at"), page, "created_at", false), &events)
+	return events, err
+}
+
+func (s *RethinkStore) ListUserEvents(userId string, page Page) (events []Event, err error) {
+	events = []Event{}
+	term := pageRange("events", "user_id_created_at", []interface{}{userId}, page, false)
+	err = s.all(pageLimit(term, page, "created_at", false), &events)
+	return events, err
+}
+
+func (s *RethinkStore) FindEvent(id string) (e Event, err error) {
+	err = s.get("events", id, &e)
+	return e, err
+}
+
+func (s *RethinkStore) CreateEvent(event Event) (e Event, err error) {
+	err = s.insert("events", event, &e)
+	return e, err
+}
+
+func (s *RethinkStore) UpdateEvent(event Event) error {
+	return s.update("events", event.Id, event)
+}
+
+func (s *RethinkStore) DeleteEvent(id string) error {
+	return s.delete("events", id)
+}
+
+func (s *RethinkStore) DeleteUserEvents(userId string) error {
+	_, err := r.Table("events").GetAllByIndex("user_id", userId).Delete().RunWrite(s.session)
+	return err
+}
+
+//// Messages
+
+func (s *RethinkStore) ListUserMessages(userId string, page Page) (messages []Message, err error) {
+	messages = []Message{}
+	term := pageRange("messages", "user_id_created_at", []interface{}{userId}, page, false)
+	err = s.all(pageLimit(term, page, "created_at", false), &messages)
+	return messages, err
+}
+
+func (s *RethinkStore) ListEventMessages(eventId string, page Page) (messages []Message, err error) {
+	messages = []Message{}
+	term := pageRange("messages", "event_id_created_at", []interface{}{eventId}, page, false)
+	err = s.all(pageLimit(term, page, "created_at", false), &messages)
+	return messages, err
+}
+
+func (s *RethinkStore) ListEventThreads(eventId string, page Page) (messages []Message, err error) {
+	messages = []Message{}
+	term := pageRange("messages", "event_id_references_created_at", []interface{}{eventId, ""}, page, false)
+	err = s.all(pageLimit(term, page, "created_at", false), &messages)
+	return messages, err
+}
+
+func (s *RethinkStore) ListMessageReplies(id string, page Page) (messages []Message, err error) {
+	messages = []Message{}
+	term := pageRange("messages", "references_created_at", []interface{}{id}, page, false)
+	err = s.all(pageLimit(term, page, "created_at", false), &messages)
+	return messages, err
+}
+
+func (s *RethinkStore) ListRepliesTo(ids []string) (messages []Message, err error) {
+	messages = []Message{}
+	if len(ids) == 0 {
+		return messages, nil
+	}
+	term := r.Table("messages").GetAllByIndex("references", stringArgs(ids)...).OrderBy(r.Asc("created_at"), r.Asc("id"))
+	err = s.all(term, &messages)
+	return messages, err
+}
+
+func (s *RethinkStore) CountReplies(ids []string) (map[string]int, error) {
+	counts := make(map[string]int, len(ids))
+	if len(ids) == 0 {
+		return counts, nil
+	}
+	var groups []struct {
+		Group     string `gorethink:"group"`
+		Reduction int    `gorethink:"reduction"`
+	}
+	err := s.all(r.Table("messages").GetAllByIndex("references", stringArgs(ids)...).Group("references").Count().Ungroup(), &groups)
+	for _, g := range groups {
+		counts[g.Group] = g.Reduction
+	}
+	return counts, err
+}
+
+func (s *RethinkStore) FindMessage(id string) (m Message, err error) {
+	err = s.get("messages", id, &m)
+	return m, err
+}
+
+func (s *RethinkStore) CreateMessage(message Message) (m Message, err error) {
+	err = s.insert("messages", message, &m)
+	return m, err
+}
+
+func (s *RethinkStore) UpdateMessage(message Message) error {
+	return s.update("messages", message.Id, message)
+}
+
+// ReviseMessage keeps the revision first, so a failed update at worst leaves
+// a revision of the current content behind
+func (s *RethinkStore) ReviseMessage(message Message, revision MessageRevision) error {
+	if err := s.insert("message_revisions", revision, &revision); err != nil {
+		return err
+	}
+	return s.update("messages", message.Id, message)
+}
+
+func (s *RethinkStore) ListMessageRevisions(id string) (revisions []MessageRevision, err error) {
+	revisions = []MessageRevision{}
+	term := r.Table("message_revisions").GetAllByIndex("message_id", id).OrderBy(r.Asc("replaced_at"), r.Asc("id"))
+	err = s.all(term, &revisions)
+	return revisions, err
+}
+
+func (s *RethinkStore) SetMessageFlag(id, flag string, at *time.Time) (m Message, err error) {
+	if flag != FlagHidden && flag != FlagPinned {
+		return m, fmt.Errorf("unknown message flag %q", flag)
+	}
+	// A nil at stores null, which decodes back to nil
+	var value interface{}
+	if at != nil {
+		value = *at
+	}
+	res, err := r.Table("messages").Get(id).Update(map[string]interface{}{flag: value}, r.UpdateOpts{ReturnChanges: true}).RunWrite(s.session)
+	if err != nil {
+		return m, err
+	}
+	if res.Skipped > 0 {
+		return m, ErrNotFound
+	}
+	if len(res.Changes) == 0 {
+		return s.FindMessage(id)
+	}
+	err = encoding.Decode(&m, res.Changes[0].NewValue)
+	return m, err
+}
+
+func (s *RethinkStore) ListEventPins(eventId string) (messages []Message, err error) {
+	messages = []Message{}
+	term := r.Table("messages").GetAllByIndex("event_id", eventId).
+		Filter(r.Row.Field("pinned_at").Default(nil).Ne(nil)).
+		OrderBy(r.Desc("pinned_at"))
+	err = s.all(term, &messages)
+	return messages, err
+}
+
+func (s *RethinkStore) DeleteMessage(id string) error {
+	if _, err := r.Table("message_revisions").GetAllByIndex("message_id", id).Delete().RunWrite(s.session); err != nil {
+		return err
+	}
+	return s.delete("messages", id)
+}
+
+func (s *RethinkStore) DeleteUserMessages(userId string) error {
+	if _, err := r.Table("message_revisions").GetAllByIndex("user_id", userId).Delete().RunWrite(s.session); err != nil {
+		return err
+	}
+	_, err := r.Table("messages").GetAllByIndex("user_id", userId).Delete().RunWrite(s.session)
+	return err
+}
+
+func (s *RethinkStore) DeleteEventMessages(eventId string) error {
+	// Revisions are indexed by message, not event
+	ids := []string{}
+	if err := s.all(r.Table("messages").GetAllByIndex("event_id", eventId).Field("id"), &ids); err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		if _, err := r.Table("message_revisions").GetAllByIndex("message_id", stringArgs(ids)...).Delete().RunWrite(s.session); err != nil {
+			return err
+		}
+	}
+	_, err := r.Table("messages").GetAllByIndex("event_id", eventId).Delete().RunWrite(s.session)
+	return err
+}
+
+// WatchEventMessages follows a changefeed on the event's messages
+func (s *RethinkStore) WatchEventMessages(eventId string, stop <-chan struct{}) (<-chan MessageChange, error) {
+	res, err := r.Table("messages").Filter(r.Row.Field("event_id").Eq(eventId)).Changes().Run(s.session)
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the cursor unblocks Next
+	go func() {
+		<-stop
+		res.Close()
+	}()
+
+	changes := make(chan MessageChange)
+	go func() {
+		defer close(changes)
+		for {
+			var change struct {
+				OldVal *Message `gorethink:"old_val"`
+				NewVal *Message `gorethink:"new_val"`
+			}
+			if !res.Next(&change) {
+				if err := res.Err(); err != nil {
+					debugf("messages changefeed for event %s ended: %v\n", eventId, err)
+				}
+				return
+			}
+
+			c := MessageChange{}
+			switch {
+			case change.OldVal == nil && change.NewVal != nil:
+				c = MessageChange{Type: MessageCreated, Message: *change.NewVal}
+			case change.NewVal == nil && change.OldVal != nil:
+				c = MessageChange{Type: MessageDeleted, Message: *change.OldVal}
+			case change.NewVal != nil && change.NewVal.DeletedAt != nil && change.OldVal.DeletedAt == nil:
+				c = MessageChange{Type: MessageDeleted, Message: *change.NewVal}
+			case change.NewVal != nil:
+				c = MessageChange{Type: MessageUpdated, Message: *change.NewVal}
+			default:
+				continue
+			}
+			select {
+			case changes <- c:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+//// Participants
+
+func (s *RethinkStore) ListUserParticipants(userId string, page Page) (participants []Participant, err error) {
+	participants = []Participant{}
+	term := pageRange("participants", "user_id_created_at", []interface{}{userId}, page, false)
+	err = s.all(withUserAndEvent(pageLimit(term, page, "created_at", false)), &participants)
+	return participants, err
+}
+
+func (s *RethinkStore) ListEventParticipants(eventId string, page Page) (participants []Participant, err error) {
+	participants = []Participant{}
+	term := pageRange("participants", "event_id_created_at", []interface{}{eventId}, page, false)
+	err = s.all(withUserAndEvent(pageLimit(term, page, "created_at", false)), &participants)
+	return participants, err
+}
+
+func (s *RethinkStore) ListEventWaitlist(eventId string, page Page) (participants []Participant, err error) {
+	participants = []Participant{}
+	// Waitlisted participants don't change until they leave the waitlist,
+	// so they've waited since updated_at
+	term := pageRange("participants", "event_id_status_updated_at", []interface{}{eventId, ParticipantWaitlisted}, page, false)
+	err = s.all(withUserAndEvent(pageLimit(term, page, "updated_at", false)), &participants)
+	return participants, err
+}
+
+func (s *RethinkStore) FindParticipant(id string) (p ParticipantWrite, err error) {
+	err = s.get("participants", id, &p)
+	return p, err
+}
+
+func (s *RethinkStore) FindEventParticipant(eventId, userId string) (p ParticipantWrite, err error) {
+	err = s.first(r.Table("participants").GetAllByIndex("event_id", eventId).Filter(r.Row.Field("user_id").Eq(userId)), &p)
+	return p, err
+}
+
+func (s *RethinkStore) CreateParticipant(participant ParticipantWrite) (p ParticipantWrite, err error) {
+	err = s.insert("participants", participant, &p)
+	return p, err
+}
+
+func (s *RethinkStore) TransitionParticipant(id string, t ParticipantTransition) (p ParticipantWrite, err error) {
+	next := ParticipantWrite{}
+	applyParticipantTransition(&next, t)
+
+	// The status check and the write are one atomic document update
+	res, err := r.Table("participants").Get(id).Update(func(row r.Term) interface{} {
+		return r.Branch(row.Field("status").Eq(t.From), map[string]interface{}{
+			"status":          next.Status,
+			"request_status":  next.RequestStatus,
+			"response_status": next.ResponseStatus,
+			"updated_at":      next.UpdatedAt,
+			"transitions":     row.Field("transitions").Default([]interface{}{}).Append(t),
+		}, map[string]interface{}{})
+	}, r.UpdateOpts{ReturnChanges: true}).RunWrite(s.session)
+	if err != nil {
+		return p, err
+	}
+	if res.Skipped > 0 {
+		return p, ErrNotFound
+	}
+	if res.Replaced == 0 || len(res.Changes) == 0 {
+		return p, ErrConflict
+	}
+	err = encoding.Decode(&p, res.Changes[0].NewValue)
+	return p, err
+}
+
+func (s *RethinkStore) DeleteParticipant(id string) error {
+	return s.delete("participants", id)
+}
+
+func (s *RethinkStore) DeleteEventParticipants(eventId string) error {
+	_, err := r.Table("participants").GetAllByIndex("event_id", eventId).Delete().RunWrite(s.session)
+	return err
+}
+
+//// Invite links
+
+func (s *RethinkStore) FindInviteLink(token string) (link InviteLink, err error) {
+	err = s.get("invite_links", token, &link)
+	return link, err
+}
+
+func (s *RethinkStore) CreateInviteLink(link InviteLink) (created InviteLink, err error) {
+	err = s.insert("invite_links", link, &created)
+	return created, err
+}
+
+func (s *RethinkStore) DeleteInviteLink(token string) error {
+	return s.delete("invite_links", token)
+}
+
+func (s *RethinkStore) DeleteEventInviteLinks(eventId string) error {
+	_, err := r.Table("invite_links").GetAllByIndex("event_id", eventId).Delete().RunWrite(s.session)
+	return err
+}
+
+func (s *RethinkStore) DeleteUserInviteLinks(userId string) error {
+	_, err := r.Table("invite_links").GetAllByIndex("user_id", userId).Delete().RunWrite(s.session)
+	return err
+}
+
+//// Seats
+
+// Seat counts live in their own table, keyed by event id, so that event
+// updates (which write the whole document) can't overwrite them
+
+func (s *RethinkStore) ReserveSeat(eventId string, capacity int) (bool, error) {
+	res, err := r.Table("seats").Get(eventId).Replace(func(row r.Term) interface{} {
+		return r.Branch(
+			row.Eq(nil), map[string]interface{}{"id": eventId, "taken": 1},
+			r.Expr(capacity <= 0).Or(row.Field("taken").Lt(capacity)), row.Merge(map[string]interface{}{"taken": row.Field("taken").Add(1)}),
+			row,
+		)
+	}).RunWrite(s.session)
+	if err != nil {
+		return false, err
+	}
+	return res.Inserted+res.Replaced == 1, nil
+}
+
+func (s *RethinkStore) ReleaseSeat(eventId string) error {
+	_, err := r.Table("seats").Get(eventId).Update(func(row r.Term) interface{} {
+		return map[string]interface{}{"taken": r.Branch(row.Field("taken").Gt(0), row.Field("taken").Sub(1), 0)}
+	}).RunWrite(s.session)
+	return err
+}
+
+func (s *RethinkStore) DeleteEventSeats(eventId string) error {
+	return s.delete("seats", eventId)
+}
+
+//// Sessions
+
+func (s *RethinkStore) FindSession(id string) (us UserSession, err error) {
+	err = s.get("sessions", id, &us)
+	return us, err
+}
+
+func (s *RethinkStore) ListUserSessions(userId string) (sessions []UserSession, err error) {
+	sessions = []UserSession{}
+	err = s.all(r.Table("sessions").GetAllByIndex("user_id", userId).OrderBy(r.Asc("created_at")), &sessions)
+	return sessions, err
+}
+
+func (s *RethinkStore) CreateSession(session UserSession) (us UserSession, err error) {
+	err = s.insert("sessions", session, &us)
+	return us, err
+}
+
+func (s *RethinkStore) UpdateSession(session UserSession) error {
+	return s.update("sessions", session.Id, session)
+}
+
+func (s *RethinkStore) DeleteSession(id string) error {
+	return s.delete("sessions", id)
+}
+
+func (s *RethinkStore) DeleteUserSessions(userId string) error {
+	_, err := r.Table("sessions").GetAllByIndex("user_id", userId).Delete().RunWrite(s.session)
+	return err
+}
+
+//// Notifications
+
+func (s *RethinkStore) CreateNotification(n Notification) (created Notification, err error) {
+	if n.Id == "" {
+		n.Id = newNotificationId(n.CreatedAt)
+	}
+	err = s.insert("notifications", n, &created)
+	return created, err
+}
+
+func (s *RethinkStore) ListUserNotifications(userId, afterId string, limit int) (notifications []Notification, err error) {
+	notifications = []Notification{}
+	term := r.Table("notifications").GetAllByIndex("user_id", userId).
+		Filter(r.Row.Field("id").Gt(afterId)).
+		OrderBy(r.Asc("id"))
+	if limit > 0 {
+		term = term.Limit(limit)
+	}
+	err = s.all(term, &notifications)
+	return notifications, err
+}
+
+func (s *RethinkStore) WatchUserNotifications(userId string, stop <-chan struct{}) (<-chan Notification, error) {
+	res, err := r.Table("notifications").Filter(r.Row.Field("user_id").Eq(userId)).Changes().Run(s.session)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-stop
+		res.Close()
+	}()
+
+	notifications := make(chan Notification)
+	go func() {
+		defer close(notifications)
+		for {
+			var change struct {
+				OldVal *Notification `gorethink:"old_val"`
+				NewVal *Notification `gorethink:"new_val"`
+			}
+			if !res.Next(&change) {
+				if err := res.Err(); err != nil {
+					debugf("notifications changefeed for user %s ended: %v\n", userId, err)
+				}
+				return
+			}
+			// Only inserts are interesting, the rest is pruning
+			if change.OldVal != nil || change.NewVal == nil {
+				continue
+			}
+			select {
+			case notifications <- *change.NewVal:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return notifications, nil
+}
+
+func (s *RethinkStore) DeleteUserNotifications(userId string) error {
+	_, err := r.Table("notifications").GetAllByIndex("user_id", userId).Delete().RunWrite(s.session)
+	return err
+}
+
+func (s *RethinkStore) DeleteNotificationsBefore(t time.Time) error {
+	_, err := r.Table("notifications").Between(r.MinVal, t, r.BetweenOpts{Index: "created_at"}).Delete().RunWrite(s.session)
+	return err
+}
+
+//// Moderation
+
+func (s *RethinkStore) FindMute(eventId, userId string) (mute Mute, err error) {
+	err = s.get("mutes", muteId(eventId, userId), &mute)
+	return mute, err
+}
+
+func (s *RethinkStore) ListEventMutes(eventId string, page Page) (mutes []Mute, err error) {
+	mutes = []Mute{}
+	term := pageRange("mutes", "event_id_created_at", []interface{}{eventId}, page, false)
+	err = s.all(pageLimit(term, page, "created_at", false), &mutes)
+	return mutes, err
+}
+
+func (s *RethinkStore) CreateMute(mute Mute) (created Mute, err error) {
+	mute.Id = muteId(mute.EventId, mute.UserId)
+	err = s.insert("mutes", mute, &created)
+	return created, err
+}
+
+func (s *RethinkStore) DeleteMute(eventId, userId string) error {
+	return s.delete("mutes", muteId(eventId, userId))
+}
+
+func (s *RethinkStore) DeleteUserMutes(userId string) error {
+	_, err := r.Table("mutes").GetAllByIndex("user_id", userId).Delete().RunWrite(s.session)
+	return err
+}
+
+func (s *RethinkStore) DeleteEventModeration(eventId string) error {
+	for _, table := range []string{"mutes", "moderation_actions"} {
+		if _, err := r.Table(table).GetAllByIndex("event_id", eventId).Delete().RunWrite(s.session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RethinkStore) CreateModerationAction(action ModerationAction) (created ModerationAction, err error) {
+	err = s.insert("moderation_actions", action, &created)
+	return created, err
+}
+
+func (s *RethinkStore) ListEventModeration(eventId string, page Page) (actions []ModerationAction, err error) {
+	actions = []ModerationAction{}
+	term := pageRange("moderation_actions", "event_id_created_at", []interface{}{eventId}, page, true)
+	err = s.all(pageLimit(term, page, "created_at", true), &actions)
+	return actions, err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamPingInterval is how often idle streams are pinged, and access rechecked
+	streamPingInterval = 30 * time.Second
+	// streamWriteWait is how long a write to a stream may take
+	streamWriteWait = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// Stream pushes JSON values to a client, over a WebSocket when the request
+// is an upgrade and as Server-Sent Events otherwise
+type Stream interface {
+	// Send writes v as the named event. id is only used by SSE, where it
+	// becomes the client's Last-Event-ID.
+	Send(event, id string, v interface{}) error
+	Ping() error
+	// Done is closed once the client has gone away
+	Done() <-chan struct{}
+	Close() error
+}
+
+// openStream upgrades the request to a WebSocket or starts an SSE response.
+// On failure the error has already been sent to the client.
+func openStream(w http.ResponseWriter, req *http.Request) (Stream, error) {
+	if websocket.IsWebSocketUpgrade(req) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return nil, err
+		}
+		return newWebSocketStream(conn), nil
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		internalError(w, errors.New("response can't be flushed"))
+		return nil, errors.New("response can't be flushed")
+	}
+	// Streams outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		debugf("Couldn't clear the write deadline of a stream: %v\n", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseStream{w: w, flusher: flusher, done: req.Context().Done()}, nil
+}
+
+//// WebSocket
+
+type webSocketStream struct {
+	conn *websocket.Conn
+	mu   sync.Mutex // one writer at a time
+	done chan struct{}
+}
+
+func newWebSocketStream(conn *websocket.Conn) *webSocketStream {
+	s := &webSocketStream{conn: conn, done: make(chan struct{})}
+
+	// Clients don't send anything but pongs and close frames; reading is
+	// still needed to process them and notice the client leaving
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+	})
+	go func() {
+		defer close(s.done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *webSocketStream) Send(event, id string, v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	return s.conn.WriteJSON(v)
+}
+
+func (s *webSocketStream) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait))
+}
+
+func (s *webSocketStream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *webSocketStream) Close() error {
+	s.mu.Lock()
+	s.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(streamWriteWait))
+	s.mu.Unlock()
+	return s.conn.Close()
+}
+
+//// Server-Sent Events
+
+type sseStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	mu      sync.Mutex
+	done    <-chan struct{}
+}
+
+func (s *sseStream) Send(event, id string, v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, js); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Ping sends a comment, which keeps proxies from timing the stream out
+func (s *sseStream) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseStream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *sseStream) Close() error {
+	return nil
+}
+
+// sessionStillValid rechecks, for long lived streams, that the session the
+// request was authenticated with hasn't been revoked or expired since
+func sessionStillValid(req *http.Request) bool {
+	s, err := store.FindSession(currentSession(req).Id)
+	return err == nil && !sessionExpired(s, time.Now())
+}
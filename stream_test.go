@@ -0,0 +1,88 @@
+package main
+
+import (
+  "bufio"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/gorilla/websocket"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+  h := NewHub()
+  fast := h.Subscribe("t", 10)
+  slow := h.Subscribe("t", 1)
+  h.Publish("t", 1)
+  h.Publish("t", 2)
+
+  assert.Equal(t, 1, <-fast)
+  assert.Equal(t, 2, <-fast)
+  assert.Equal(t, 1, <-slow)
+  _, open := <-slow
+  assert.False(t, open)
+
+  h.Unsubscribe("t", fast)
+  _, open = <-fast
+  assert.False(t, open)
+}
+
+func TestStreamEventMessagesWebSocket(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyParticipants)
+  server := httptest.NewServer(initRouting())
+  defer server.Close()
+  url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/events/" + f.event.Id + "/messages/stream"
+
+  // same privacy checks as the REST route
+  _, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + f.stranger}})
+  assert.NotNil(t, err)
+  assert.Equal(t, 403, resp.StatusCode)
+  _, resp, err = websocket.DefaultDialer.Dial(url, nil)
+  assert.NotNil(t, err)
+  assert.Equal(t, 401, resp.StatusCode)
+
+  conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + f.member}})
+  assert.Nil(t, err)
+  defer conn.Close()
+
+  // the subscription starts before the upgrade completes, so this can't be missed
+  posted := doRequest("POST", "/api/v1/events/" + f.event.Id + "/messages", f.member, `{"message": {"content": "The night is dark"}}`)
+  assert.Equal(t, 200, posted.Code)
+
+  change := MessageChange{}
+  conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+  assert.Nil(t, conn.ReadJSON(&change))
+  assert.Equal(t, MessageCreated, change.Type)
+  assert.Equal(t, "The night is dark", change.Message.Content)
+
+  store.DeleteMessage(change.Message.Id)
+  assert.Nil(t, conn.ReadJSON(&change))
+  assert.Equal(t, MessageDeleted, change.Type)
+}
+
+func TestStreamEventMessagesSSE(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  server := httptest.NewServer(initRouting())
+  defer server.Close()
+
+  req, _ := http.NewRequest("GET", server.URL + "/api/v1/events/" + f.event.Id + "/messages/stream", nil)
+  req.Header.Set("Authorization", "Bearer " + f.stranger)
+  resp, err := http.DefaultClient.Do(req)
+  assert.Nil(t, err)
+  defer resp.Body.Close()
+  assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+  store.CreateMessage(Message{EventId: f.event.Id, Content: "Winter is coming"})
+  // messages of other events aren't sent
+  store.CreateMessage(Message{EventId: "elsewhere", Content: "Summer"})
+
+  lines := bufio.NewReader(resp.Body)
+  event, _ := lines.ReadString('\n')
+  data, _ := lines.ReadString('\n')
+  assert.Equal(t, "event: created\n", event)
+  assert.True(t, strings.HasPrefix(data, "data: {"))
+  assert.Contains(t, data, "Winter is coming")
+}
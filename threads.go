@@ -0,0 +1,63 @@
+package main
+
+// maxThreadDepth bounds how deep nested threads are built. Deeper replies
+// are left out, the replies route still lists them.
+const maxThreadDepth = 32
+
+// countReplies sets the ReplyCount of each message
+func countReplies(messages []Message) error {
+	ids := make([]string, len(messages))
+	for i, m := range messages {
+		ids[i] = m.Id
+	}
+	counts, err := store.CountReplies(ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].ReplyCount = counts[messages[i].Id]
+	}
+	return nil
+}
+
+// buildThreads nests the replies, and theirs, under each of roots
+func buildThreads(roots []Message) ([]MessageThread, error) {
+	if err := countReplies(roots); err != nil {
+		return nil, err
+	}
+	threads := make([]MessageThread, len(roots))
+	level := make([]*MessageThread, len(roots))
+	for i, m := range roots {
+		threads[i] = MessageThread{Message: m, Replies: []MessageThread{}}
+		level[i] = &threads[i]
+	}
+
+	for depth := 0; depth < maxThreadDepth && len(level) > 0; depth++ {
+		byId := make(map[string]*MessageThread, len(level))
+		ids := make([]string, len(level))
+		for i, t := range level {
+			byId[t.Id] = t
+			ids[i] = t.Id
+		}
+		replies, err := store.ListRepliesTo(ids)
+		if err != nil {
+			return nil, err
+		}
+		if err := countReplies(replies); err != nil {
+			return nil, err
+		}
+
+		// Add every reply before taking pointers, appending may move them
+		for _, m := range replies {
+			parent := byId[m.References]
+			parent.Replies = append(parent.Replies, MessageThread{Message: m, Replies: []MessageThread{}})
+		}
+		level = level[:0]
+		for _, t := range byId {
+			for i := range t.Replies {
+				level = append(level, &t.Replies[i])
+			}
+		}
+	}
+	return threads, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+  "encoding/json"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+// postMessage posts content to the fixture's event as sid, in reply to ref
+func postMessage(t *testing.T, f privacyFixture, sid, content, ref string) Message {
+  var body struct{ Message Message }
+  res := doRequest("POST", "/api/v1/events/" + f.event.Id + "/messages", sid,
+    `{"message": {"content": "` + content + `", "references": "` + ref + `"}}`)
+  assert.Equal(t, 200, res.Code, res.Body.String())
+  json.Unmarshal(res.Body.Bytes(), &body)
+  return body.Message
+}
+
+func TestReplyReferencesSameEvent(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  root := postMessage(t, f, f.host, "Dracarys", "")
+  url := "/api/v1/events/" + f.event.Id + "/messages"
+
+  other, _ := store.CreateEvent(Event{UserId: root.UserId, Title: "Ships", CreatedAt: time.Now()})
+  elsewhere, _ := store.CreateMessage(Message{EventId: other.Id, UserId: root.UserId, Content: "Sail", CreatedAt: time.Now()})
+  assert.Equal(t, 422, doRequest("POST", url, f.member, `{"message": {"content": "?", "references": "` + elsewhere.Id + `"}}`).Code)
+  assert.Equal(t, 422, doRequest("POST", url, f.member, `{"message": {"content": "?", "references": "nope"}}`).Code)
+
+  reply := postMessage(t, f, f.member, "Yes, Khaleesi", root.Id)
+  assert.Equal(t, root.Id, reply.References)
+
+  // replies stay where they are
+  assert.Equal(t, 422, doRequest("PUT", "/api/v1/messages/" + reply.Id, f.member, `{"message": {"references": ""}}`).Code)
+  assert.Equal(t, 200, doRequest("PUT", "/api/v1/messages/" + reply.Id, f.member, `{"message": {"content": "Yes", "references": "` + root.Id + `"}}`).Code)
+}
+
+func TestMessageReplies(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyInviteOnly)
+  root := postMessage(t, f, f.host, "Dracarys", "")
+  first := postMessage(t, f, f.member, "Yes, Khaleesi", root.Id)
+  postMessage(t, f, f.host, "Now", root.Id)
+  postMessage(t, f, f.member, "Right away", first.Id)
+
+  assert.Equal(t, 404, doRequest("GET", "/api/v1/messages/" + root.Id + "/replies", f.stranger, "").Code)
+
+  var body struct{ Messages []Message }
+  res := doRequest("GET", "/api/v1/messages/" + root.Id + "/replies", f.member, "")
+  assert.Equal(t, 200, res.Code)
+  json.Unmarshal(res.Body.Bytes(), &body)
+  if assert.Len(t, body.Messages, 2) {
+    assert.Equal(t, first.Id, body.Messages[0].Id)
+    assert.Equal(t, 1, body.Messages[0].ReplyCount)
+    assert.Equal(t, 0, body.Messages[1].ReplyCount)
+  }
+
+  // the flat list counts replies too
+  res = doRequest("GET", "/api/v1/events/" + f.event.Id + "/messages?per=100", f.member, "")
+  json.Unmarshal(res.Body.Bytes(), &body)
+  counts := map[string]int{}
+  for _, m := range body.Messages {
+    counts[m.Id] = m.ReplyCount
+  }
+  assert.Equal(t, 2, counts[root.Id])
+  assert.Equal(t, 1, counts[first.Id])
+}
+
+func TestEventMessagesThreadTree(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  root := postMessage(t, f, f.host, "Dracarys", "")
+  first := postMessage(t, f, f.member, "Yes, Khaleesi", root.Id)
+  second := postMessage(t, f, f.host, "Now", root.Id)
+  deep := postMessage(t, f, f.member, "Right away", first.Id)
+
+  var body struct{ Threads []MessageThread }
+  res := doRequest("GET", "/api/v1/events/" + f.event.Id + "/messages?thread=tree", f.member, "")
+  assert.Equal(t, 200, res.Code)
+  json.Unmarshal(res.Body.Bytes(), &body)
+
+  // the fixture's message and root start threads, replies don't
+  assert.Len(t, body.Threads, 2)
+  var tree MessageThread
+  for _, thread := range body.Threads {
+    if thread.Id == root.Id {
+      tree = thread
+    }
+  }
+  assert.Equal(t, 2, tree.ReplyCount)
+  if assert.Len(t, tree.Replies, 2) {
+    assert.Equal(t, first.Id, tree.Replies[0].Id)
+    assert.Equal(t, second.Id, tree.Replies[1].Id)
+    if assert.Len(t, tree.Replies[0].Replies, 1) {
+      assert.Equal(t, deep.Id, tree.Replies[0].Replies[0].Id)
+    }
+    assert.Empty(t, tree.Replies[1].Replies)
+  }
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// localTimeFormat is an RFC 3339 date without its offset, a wall clock time
+// in the event's timezone
+const localTimeFormat = "2006-01-02T15:04:05.999999999"
+
+// errSkippedTime is a wall clock time that doesn't exist in a timezone,
+// like 02:30 on the night clocks spring forward
+var errSkippedTime = errors.New("time skipped by a daylight saving change")
+
+// parseTime parses a date sent by a client, in loc when it doesn't say
+// otherwise. Dates are RFC 3339, "2017-01-02T15:04:05-05:00", or without the
+// offset to mean that wall clock time in loc; with config.LegacyTimeFormat
+// TimeFormat is still accepted. The date is returned in loc.
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.In(loc), nil
+	}
+	if wall, err := time.ParseInLocation(localTimeFormat, s, time.UTC); err == nil {
+		return wallClock(wall, loc)
+	}
+	if config.LegacyTimeFormat {
+		if t, err := time.Parse(TimeFormat, s); err == nil {
+			return t.In(loc), nil
+		}
+	}
+	return time.Time{}, errors.New("unknown date format")
+}
+
+// wallClock finds when the clocks of loc show wall, whose fields are read as
+// a wall clock time. Around a daylight saving change the time may happen
+// twice, when clocks fall back, the first one is picked, or not at all, when
+// they spring forward, which is errSkippedTime.
+func wallClock(wall time.Time, loc *time.Location) (time.Time, error) {
+	var found time.Time
+	// the offsets in use half a day before and after cover any change
+	for _, probe := range []time.Duration{-12 * time.Hour, 12 * time.Hour} {
+		_, offset := wall.Add(probe).In(loc).Zone()
+		t := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+		if !sameWallClock(t, wall) {
+			continue
+		}
+		if found.IsZero() || t.Before(found) {
+			found = t
+		}
+	}
+	if found.IsZero() {
+		return time.Time{}, errSkippedTime
+	}
+	return found, nil
+}
+
+func sameWallClock(t, wall time.Time) bool {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC).Equal(wall)
+}
+
+// loadTimezone loads an IANA time zone, UTC for "". "Local" is refused,
+// it'd be whatever the server runs in.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "Local" {
+		return nil, errors.New("unknown time zone Local")
+	}
+	return time.LoadLocation(name)
+}
+
+// location is the timezone of the event's dates, UTC if it's missing or
+// can't be loaded
+func (e Event) location() *time.Location {
+	loc, err := loadTimezone(e.Timezone)
+	if err != nil {
+		warnf("Event %s has unknown timezone %q: %v", e.Id, e.Timezone, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// inZone moves a set date to loc, so it's answered with loc's offset at
+// that date. Unset dates stay the zero time.
+func inZone(t time.Time, loc *time.Location) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.In(loc)
+}
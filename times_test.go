@@ -0,0 +1,66 @@
+package main
+
+import (
+  "encoding/json"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestParseTime(t *testing.T) {
+  ny, err := loadTimezone("America/New_York")
+  assert.Nil(t, err)
+
+  // an offset wins over the timezone
+  d, err := parseTime("2017-07-04T12:00:00+02:00", ny)
+  assert.Nil(t, err)
+  assert.Equal(t, "2017-07-04T06:00:00-04:00", d.Format(time.RFC3339))
+
+  // a wall clock time is read in the timezone, with its offset at that date
+  d, _ = parseTime("2017-01-02T15:04:05", ny)
+  assert.Equal(t, "2017-01-02T15:04:05-05:00", d.Format(time.RFC3339))
+  d, _ = parseTime("2017-07-04T15:04:05", ny)
+  assert.Equal(t, "2017-07-04T15:04:05-04:00", d.Format(time.RFC3339))
+
+  // clocks spring forward from 2:00 to 3:00, and fall back from 2:00 to 1:00
+  _, err = parseTime("2017-03-12T02:30:00", ny)
+  assert.Equal(t, errSkippedTime, err)
+  d, _ = parseTime("2017-11-05T01:30:00", ny)
+  assert.Equal(t, "2017-11-05T01:30:00-04:00", d.Format(time.RFC3339))
+
+  d, _ = parseTime("Mon Jan 2 2017 15:04:05 EST-05:00", time.UTC)
+  assert.Equal(t, "2017-01-02T20:04:05Z", d.Format(time.RFC3339))
+  config.LegacyTimeFormat = false
+  _, err = parseTime("Mon Jan 2 2017 15:04:05 EST-05:00", time.UTC)
+  assert.NotNil(t, err)
+  config.LegacyTimeFormat = true
+
+  _, err = loadTimezone("Local")
+  assert.NotNil(t, err)
+}
+
+func TestEventTimezone(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Davos")
+  url := "/api/v1/users/" + user.Id + "/events"
+
+  var body struct{ Event Event }
+  resp := doRequest("POST", url, sid, `{"event": {"title": "Onion run", "timezone": "America/New_York",
+    "start_date": "2017-11-04T20:00:00", "end_date": "2017-11-05T20:00:00"}}`)
+  assert.Equal(t, 200, resp.Code, resp.Body.String())
+  assert.Contains(t, resp.Body.String(), `"start_date":"2017-11-04T20:00:00-04:00"`)
+  assert.Contains(t, resp.Body.String(), `"end_date":"2017-11-05T20:00:00-05:00"`)
+  json.Unmarshal(resp.Body.Bytes(), &body)
+  assert.Equal(t, 25 * time.Hour, body.Event.EndDate.Sub(body.Event.StartDate))
+
+  // moving the venue keeps the moment and changes how it's shown
+  resp = doRequest("PUT", url + "/" + body.Event.Id, sid, `{"event": {"timezone": "Europe/London"}}`)
+  assert.Equal(t, 200, resp.Code, resp.Body.String())
+  assert.Contains(t, resp.Body.String(), `"start_date":"2017-11-05T00:00:00Z"`)
+  resp = doRequest("GET", "/api/v1/events/" + body.Event.Id, sid, "")
+  assert.Contains(t, resp.Body.String(), `"timezone":"Europe/London"`)
+
+  resp = doRequest("PUT", url + "/" + body.Event.Id, sid, `{"event": {"timezone": "Westeros/Dragonstone", "start_date": "2017-03-26T01:30:00"}}`)
+  assert.Equal(t, map[string]string{"timezone": FieldInvalid, "start_date": FieldInvalid}, fieldErrors(t, resp))
+}
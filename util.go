@@ -1,21 +1,27 @@
 package main
 
 import (
-  r "github.com/dancannon/gorethink"
-  "github.com/dancannon/gorethink/encoding"
 	"html/template"
 	"log"
 	"net/http"
   "encoding/json"
 	"os"
 	"path/filepath"
-  "time"
+  "bytes"
+  "io/ioutil"
+  "reflect"
+  "math"
   "strconv"
 )
 
 var templates *template.Template
 
 func init() {
+	// Templates are optional, the JSON API runs fine without them
+	if _, err := os.Stat("templates"); os.IsNotExist(err) {
+		return
+	}
+
 	filenames := []string{}
 	err := filepath.Walk("templates", func(path string, info os.FileInfo, err error) error {
 		if !info.IsDir() && filepath.Ext(path) == ".gohtml" {
@@ -41,18 +47,23 @@ func init() {
 func renderTemplate(w http.ResponseWriter, tmpl string, vars interface{}) {
 	err := templates.ExecuteTemplate(w, tmpl+".gohtml", vars)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		internalError(w, err)
 	}
 }
 
 func sendJson(v interface{}, w http.ResponseWriter) {
+  sendJsonStatus(http.StatusOK, v, w)
+}
+
+func sendJsonStatus(status int, v interface{}, w http.ResponseWriter) {
   js, err := json.Marshal(v)
   if err != nil {
-    http.Error(w, err.Error(), http.StatusInternalServerError)
+    internalError(w, err)
     return
   }
 
   w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
   w.Write(js)
 }
 
@@ -60,7 +71,7 @@ func readIntFromUrlParam(param string, val *int, w http.ResponseWriter, req *htt
   if len([]rune(param)) > 0 {
     tmpVal, err := strconv.Atoi(param)
     if err != nil {
-      http.Error(w, err.Error(), http.StatusInternalServerError)
+      badRequest(w, "Invalid integer " + strconv.Quote(param))
       return false
     }
     *val = tmpVal
@@ -69,92 +80,203 @@ func readIntFromUrlParam(param string, val *int, w http.ResponseWriter, req *htt
   return true
 }
 
-func readBody(p interface{}, w http.ResponseWriter, req *http.Request) bool {
-  decoder := json.NewDecoder(req.Body)
-  err := decoder.Decode(&p)
-  if err != nil {
-    http.Error(w, err.Error(), http.StatusInternalServerError)
-    return false
+// readPagination reads the page & per URL params. per defaults to the
+// configured page size and is capped at the configured maximum.
+func readPagination(w http.ResponseWriter, req *http.Request) (page int, per int, ok bool) {
+  page = 1
+  per  = config.Pagination.DefaultPer
+  if ok := readIntFromUrlParam(req.URL.Query().Get("page"), &page, w, req); !ok {
+    return 0, 0, false
   }
-  log.Printf("Params:%+v\n", p)
-  return true
+  if ok := readIntFromUrlParam(req.URL.Query().Get("per"), &per, w, req); !ok {
+    return 0, 0, false
+  }
+  if page < 1 {
+    page = 1
+  }
+  if per < 1 {
+    per = config.Pagination.DefaultPer
+  }
+  if per > config.Pagination.MaxPer {
+    per = config.Pagination.MaxPer
+  }
+  log.Printf("(after) page = %+v | per = %+v\n", page, per)
+  return page, per, true
 }
 
-func fetchSessionByUser(user_id string) (s UserSession, err error) {
-  res, err := r.Table("sessions").GetAllByIndex("user_id", user_id).Run(session)
-  if err != nil {
-    return s, err
+// readPage reads per and either after or before, cursors from the next and
+// prev links of an earlier page, or else the legacy page param. See
+// readPagination.
+func readPage(w http.ResponseWriter, req *http.Request) (Page, bool) {
+  number, per, ok := readPagination(w, req)
+  if !ok {
+    return Page{}, false
   }
+  page := Page{Number: number, Per: per}
 
-  if res.IsNil() {
-    log.Println("fetchSession: if res isNil")
-    t := time.Now()
-    tmp_s := UserSession{
-      UserId: user_id,
-      CreatedAt: t,
-      UpdatedAt: t,
+  after, before := req.URL.Query().Get("after"), req.URL.Query().Get("before")
+  if after != "" && before != "" {
+    badRequest(w, "Only one of after or before can be given")
+    return Page{}, false
+  }
+  if after != "" {
+    c, err := parseCursor(after)
+    if err != nil {
+      badRequest(w, "Invalid after")
+      return Page{}, false
     }
-    res2, _ := r.Table("sessions").Insert(tmp_s, r.InsertOpts{ReturnChanges: true}).RunWrite(session)
-    encoding.Decode(&s, res2.Changes[0].NewValue) // using reflection
-  } else {
-    log.Println("fetchSession: else res is not nil")
-    res.One(&s)
+    page.After = &c
   }
-  return s, err
+  if before != "" {
+    c, err := parseCursor(before)
+    if err != nil {
+      badRequest(w, "Invalid before")
+      return Page{}, false
+    }
+    page.Before = &c
+  }
+  return page, true
 }
 
-func fetchUserFromSession(u *User, sid string, id string, w http.ResponseWriter, req *http.Request) bool {
-  var userSession UserSession
-  var sessionUser User
+// pageLinks returns the links to the pages next to page, which has n items,
+// or nil at either end of the list. cursor gives the position of item i;
+// without it the links are to offset pages.
+func pageLinks(page Page, n int, cursor func(i int) Cursor, req *http.Request) (next, prev *string) {
+  link := func(param, value string) *string {
+    q := req.URL.Query()
+    for _, p := range []string{"page", "after", "before", "sid"} {
+      q.Del(p)
+    }
+    q.Set(param, value)
+    l := req.URL.Path + "?" + q.Encode()
+    return &l
+  }
 
-  // Ensure Request was passed a session ID
-  if len([]rune(sid)) == 0 {
-    http.Error(w, "Missing Session ID", http.StatusBadRequest)
-    return false
+  if cursor == nil {
+    if n >= page.Per {
+      next = link("page", strconv.Itoa(page.Number + 1))
+    }
+    if page.Number > 1 {
+      prev = link("page", strconv.Itoa(page.Number - 1))
+    }
+    return next, prev
   }
 
-  // Lookup Session in DB
-  res, err := r.Table("sessions").Get(sid).Run(session)
-  if err != nil {
-    http.Error(w, err.Error(), http.StatusInternalServerError)
-    return false
+  // A short page is the end of the list in the direction it was read
+  full := n >= page.Per
+  switch {
+  case n > 0:
+    if full || page.Before != nil {
+      next = link("after", cursor(n - 1).String())
+    }
+    if page.After != nil || (page.Before != nil && full) || (page.Before == nil && page.Number > 1) {
+      prev = link("before", cursor(0).String())
+    }
+  case page.After != nil:
+    prev = link("before", page.After.String())
+  case page.Before != nil:
+    next = link("after", page.Before.String())
   }
-  if res.IsNil() {
-    http.Error(w, "Couldn't find Session with ID: " + sid, http.StatusNotFound)
+  return next, prev
+}
+
+// sendPage writes a page of items under key, with the "page" and "per" asked
+// for and the "next" and "prev" links
+func sendPage(key string, items interface{}, page Page, n int, cursor func(i int) Cursor, w http.ResponseWriter, req *http.Request) {
+  next, prev := pageLinks(page, n, cursor, req)
+  sendJson(map[string]interface{}{
+    key: items,
+    "page": strconv.Itoa(page.Number),
+    "per": strconv.Itoa(page.Per),
+    "next": next,
+    "prev": prev,
+  }, w)
+}
+
+// readFloatFromUrlParam parses an optional float URL param, answering 400 if it's malformed
+func readFloatFromUrlParam(name string, val *float64, w http.ResponseWriter, req *http.Request) bool {
+  param := req.URL.Query().Get(name)
+  if len(param) == 0 {
+    return true
+  }
+  tmpVal, err := strconv.ParseFloat(param, 64)
+  if err != nil || math.IsNaN(tmpVal) || math.IsInf(tmpVal, 0) {
+    badRequest(w, "Invalid " + name)
     return false
   }
-  res.One(&userSession)
+  *val = tmpVal
+  return true
+}
 
-  // Lookup User in DB from Session
-	res, err = r.Table("users").Get(userSession.UserId).Run(session)
-	if err != nil {
-    http.Error(w, err.Error(), http.StatusInternalServerError)
-    return false
+// readNearParams reads the lat, lon & radius_km URL params of a location search.
+// radius_km defaults to the configured radius and is capped at the configured maximum.
+func readNearParams(w http.ResponseWriter, req *http.Request) (lat, lon, radius_km float64, ok bool) {
+  q := req.URL.Query()
+  if len(q.Get("lat")) == 0 || len(q.Get("lon")) == 0 {
+    badRequest(w, "lat and lon must be given together")
+    return 0, 0, 0, false
   }
-  if res.IsNil() {
-    http.Error(w, "Couldn't find User matching Session", http.StatusNotFound)
-    return false
-	}
-  res.One(&sessionUser)
+  radius_km = config.Geo.DefaultRadiusKm
+  if !readFloatFromUrlParam("lat", &lat, w, req) ||
+     !readFloatFromUrlParam("lon", &lon, w, req) ||
+     !readFloatFromUrlParam("radius_km", &radius_km, w, req) {
+    return 0, 0, 0, false
+  }
+  if !validLatLon(lat, lon) {
+    badRequest(w, "lat must be within [-90, 90] and lon within [-180, 180]")
+    return 0, 0, 0, false
+  }
+  if radius_km <= 0 {
+    badRequest(w, "radius_km must be positive")
+    return 0, 0, 0, false
+  }
+  if radius_km > config.Geo.MaxRadiusKm {
+    radius_km = config.Geo.MaxRadiusKm
+  }
+  return lat, lon, radius_km, true
+}
 
-  // if no id is passed just return the user in the session
-  if(len(id) == 0) {
-    *u = sessionUser
+// readBody decodes the JSON request body into p, a pointer to a struct. An
+// empty body is fine, authenticated DELETEs don't need one anymore. Fields of
+// the wrong JSON type, and in strict mode fields p doesn't have (see
+// wantsStrict), are answered with a 422.
+func readBody(p interface{}, w http.ResponseWriter, req *http.Request) bool {
+  body, err := ioutil.ReadAll(req.Body)
+  if err != nil {
+    badRequest(w, "Couldn't read the body: " + err.Error())
+    return false
+  }
+  if len(bytes.TrimSpace(body)) == 0 {
     return true
   }
 
-  // Find User from ID passed in
-  if ok := findUser(id, u, w, req); !ok {
+  v := Validation{}
+  if ok := decodeParams(body, p, &v, w); !ok {
     return false
   }
-
-  // if User ID passed does not equal User ID in Session
-  log.Printf("Session  User.Id: %+v\n", sessionUser.Id)
-  log.Printf("Resource User.Id: %+v\n", u.Id)
-  if sessionUser.Id != u.Id {
-    http.Error(w, "Users are not equal", http.StatusBadRequest)
+  if wantsStrict(req.Header["Prefer"]) {
+    w.Header().Set("Preference-Applied", "handling=strict")
+    for _, field := range unknownFields(body, reflect.TypeOf(p).Elem(), "") {
+      v.Add(field, FieldUnknown, field + " isn't a known field")
+    }
+  }
+  if !v.Valid() {
+    sendValidation(v, w)
     return false
   }
+  debugf("Params: %+v\n", p)
+  return true
+}
 
+// decodeParams unmarshals body into p, adding a value of the wrong JSON type
+// to v. Malformed JSON is answered with a 400.
+func decodeParams(body []byte, p interface{}, v *Validation, w http.ResponseWriter) bool {
+  err := json.Unmarshal(body, p)
+  if terr, ok := err.(*json.UnmarshalTypeError); ok && terr.Field != "" {
+    v.Add(terr.Field, FieldInvalid, terr.Field + " must be a " + terr.Type.String())
+  } else if err != nil {
+    badRequest(w, "Malformed JSON body: " + err.Error())
+    return false
+  }
   return true
 }
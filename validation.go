@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Longest text fields accepted, in characters
+const (
+	maxNameLength        = 100
+	maxTitleLength       = 200
+	maxBioLength         = 2000
+	maxDescriptionLength = 10000
+	maxContentLength     = 4000
+	maxUrlLength         = 2048
+)
+
+// Field error codes, see FieldError
+const (
+	FieldRequired   = "required"     // missing or blank
+	FieldInvalid    = "invalid"      // not of the field's type or format
+	FieldTooLong    = "too_long"     // longer than the field's limit
+	FieldOutOfRange = "out_of_range" // a number or date outside what the field allows
+	FieldUnknown    = "unknown"      // not a field of the resource, only in strict mode and PATCH
+	FieldReadOnly   = "read_only"    // a field of the resource PATCH can't change
+)
+
+// FieldError is a problem with one field of a write payload
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Validation collects every problem with a write payload so clients hear
+// about all of them at once. Handlers parse the payload with its helpers,
+// check the resulting record with the resource's validate function and
+// answer with sendValidation unless it's Valid.
+type Validation struct {
+	Errors []FieldError
+}
+
+func (v *Validation) Add(field, code, message string) {
+	v.Errors = append(v.Errors, FieldError{field, code, message})
+}
+
+func (v *Validation) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// Has reports whether field already has an error, later rules skip it so a
+// field that didn't parse isn't also reported as out of range
+func (v *Validation) Has(field string) bool {
+	for _, e := range v.Errors {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+//// Reading Request fields. Each returns whether the field was given and
+//// valid, and was copied to dst.
+
+func (v *Validation) Int(field string, src *Int, dst *int) bool {
+	if src == nil {
+		return false
+	}
+	if src.Invalid != "" {
+		v.Add(field, FieldInvalid, field+" must be a whole number")
+		return false
+	}
+	*dst = src.Value
+	return true
+}
+
+func (v *Validation) Float(field string, src *Float, dst *float64) bool {
+	if src == nil {
+		return false
+	}
+	if src.Invalid != "" {
+		v.Add(field, FieldInvalid, field+" must be a number")
+		return false
+	}
+	*dst = src.Value
+	return true
+}
+
+func (v *Validation) Time(field string, src *string, loc *time.Location, dst *time.Time) bool {
+	if src == nil {
+		return false
+	}
+	t, err := parseTime(*src, loc)
+	if err == errSkippedTime {
+		v.Add(field, FieldInvalid, fmt.Sprintf("%s is skipped by a daylight saving change in %s", field, loc))
+		return false
+	}
+	if err != nil {
+		v.Add(field, FieldInvalid, field+" must be an RFC 3339 date, like \"2017-01-02T15:04:05-05:00\"")
+		return false
+	}
+	*dst = t
+	return true
+}
+
+// Timezone reads an IANA time zone name, see loadTimezone
+func (v *Validation) Timezone(field string, src *string, dst *string) bool {
+	if src == nil {
+		return false
+	}
+	if _, err := loadTimezone(*src); err != nil {
+		v.Add(field, FieldInvalid, field+" must be an IANA time zone, like \"America/New_York\"")
+		return false
+	}
+	*dst = *src
+	return true
+}
+
+// Location reads lon and lat, which are given together
+func (v *Validation) Location(lon, lat *Float, dst *Location) bool {
+	switch {
+	case lon == nil && lat == nil:
+		return false
+	case lon == nil:
+		v.Add("lon", FieldRequired, "lon is required with lat")
+		return false
+	case lat == nil:
+		v.Add("lat", FieldRequired, "lat is required with lon")
+		return false
+	}
+
+	l := Location{0, 0}
+	lon_ok := v.Float("lon", lon, &l[0])
+	lat_ok := v.Float("lat", lat, &l[1])
+	if !lon_ok || !lat_ok {
+		return false
+	}
+	*dst = l
+	return true
+}
+
+//// Rules
+
+func (v *Validation) Required(field, value string) {
+	if !v.Has(field) && strings.TrimSpace(value) == "" {
+		v.Add(field, FieldRequired, field+" is required")
+	}
+}
+
+func (v *Validation) MaxLength(field, value string, max int) {
+	if !v.Has(field) && utf8.RuneCountInString(value) > max {
+		v.Add(field, FieldTooLong, fmt.Sprintf("%s can't be longer than %d characters", field, max))
+	}
+}
+
+func (v *Validation) Range(field string, value, min, max float64) {
+	if !v.Has(field) && (value < min || value > max) {
+		v.Add(field, FieldOutOfRange, fmt.Sprintf("%s must be within [%g, %g]", field, min, max))
+	}
+}
+
+// Email checks a non empty value is a bare address, like "arya@winterfell.com"
+func (v *Validation) Email(field, value string) {
+	if v.Has(field) || value == "" {
+		return
+	}
+	a, err := mail.ParseAddress(value)
+	if err != nil || a.Address != value {
+		v.Add(field, FieldInvalid, field+" must be an email address")
+	}
+}
+
+// Url checks a non empty value is an absolute http or https URL
+func (v *Validation) Url(field, value string) {
+	if v.Has(field) || value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.Add(field, FieldInvalid, field+" must be an http or https URL")
+		return
+	}
+	v.MaxLength(field, value, maxUrlLength)
+}
+
+// sendValidation answers with a 422 listing every field error
+func sendValidation(v Validation, w http.ResponseWriter) {
+	message := v.Errors[0].Message
+	if len(v.Errors) > 1 {
+		message = fmt.Sprintf("%s, and %d more problem(s)", message, len(v.Errors)-1)
+	}
+	sendError(w, http.StatusUnprocessableEntity, CodeValidationFailed, message, map[string]interface{}{"fields": v.Errors})
+}
+
+// invalidField answers a payload with a single bad field, see sendValidation
+func invalidField(w http.ResponseWriter, field, message string) {
+	sendValidation(Validation{[]FieldError{{field, FieldInvalid, message}}}, w)
+}
+
+//// Resources
+
+func validateUser(u User, v *Validation) {
+	v.MaxLength("first_name", u.FirstName, maxNameLength)
+	v.MaxLength("last_name", u.LastName, maxNameLength)
+	v.Email("email", u.Email)
+	v.Url("avatar", u.Avatar)
+	v.MaxLength("bio", u.Bio, maxBioLength)
+}
+
+func validateEvent(e Event, v *Validation) {
+	v.Required("title", e.Title)
+	v.MaxLength("title", e.Title, maxTitleLength)
+	v.MaxLength("description", e.Description, maxDescriptionLength)
+	v.Url("picture_url", e.PictureUrl)
+	if !v.Has("privacy_level") && !validPrivacyLevel(e.PrivacyLevel) {
+		v.Add("privacy_level", FieldOutOfRange, "privacy_level must be 0 (public), 1 (participants-only), 2 (invite-only) or 3 (private)")
+	}
+	if !v.Has("capacity") && e.Capacity < 0 {
+		v.Add("capacity", FieldOutOfRange, "capacity can't be negative, 0 is unlimited")
+	}
+	if len(e.Location) == 2 {
+		v.Range("lon", e.Location[0], -180, 180)
+		v.Range("lat", e.Location[1], -90, 90)
+	}
+	if !e.StartDate.IsZero() && !e.EndDate.IsZero() && !v.Has("start_date") && !v.Has("end_date") && !e.EndDate.After(e.StartDate) {
+		v.Add("end_date", FieldOutOfRange, "end_date must be after start_date")
+	}
+}
+
+func validateMessage(m Message, v *Validation) {
+	v.Required("content", m.Content)
+	v.MaxLength("content", m.Content, maxContentLength)
+}
+
+func validateParticipantStatus(status string, v *Validation) {
+	if !v.Has("status") && !validParticipantStatus(status) {
+		v.Add("status", FieldInvalid, "status must be one of "+strings.Join(participantStatuses(), ", "))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http/httptest"
+  "strings"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+// fieldErrors decodes a 422 into the code of each field's error
+func fieldErrors(t *testing.T, resp *httptest.ResponseRecorder) map[string]string {
+  assert.Equal(t, 422, resp.Code, resp.Body.String())
+  var body struct {
+    Error struct {
+      Code    string
+      Details struct{ Fields []FieldError }
+    }
+  }
+  json.Unmarshal(resp.Body.Bytes(), &body)
+  assert.Equal(t, CodeValidationFailed, body.Error.Code)
+  fields := map[string]string{}
+  for _, f := range body.Error.Details.Fields {
+    fields[f.Field] = f.Code
+  }
+  return fields
+}
+
+func TestEventValidation(t *testing.T) {
+  store = NewMemoryStore()
+  user, sid := newTestUser(t, "Samwell")
+  url := "/api/v1/users/" + user.Id + "/events"
+
+  // every problem is reported at once
+  resp := doRequest("POST", url, sid, `{"event": {
+    "privacy_level": "two",
+    "capacity": "-1",
+    "lat": "91",
+    "lon": "0",
+    "picture_url": "javascript:alert(1)",
+    "start_date": "tomorrow"
+  }}`)
+  assert.Equal(t, map[string]string{
+    "title": FieldRequired,
+    "privacy_level": FieldInvalid,
+    "capacity": FieldOutOfRange,
+    "lat": FieldOutOfRange,
+    "picture_url": FieldInvalid,
+    "start_date": FieldInvalid,
+  }, fieldErrors(t, resp))
+
+  resp = doRequest("POST", url, sid, `{"event": {"title": "Oldtown", "lat": "39.95",
+    "start_date": "Mon Jan 2 2017 15:04:05 EST-05:00", "end_date": "Mon Jan 2 2017 12:00:00 EST-05:00"}}`)
+  assert.Equal(t, map[string]string{"lon": FieldRequired, "end_date": FieldOutOfRange}, fieldErrors(t, resp))
+
+  resp = doRequest("POST", url, sid, `{"event": {"title": "` + strings.Repeat("a", maxTitleLength + 1) + `"}}`)
+  assert.Equal(t, map[string]string{"title": FieldTooLong}, fieldErrors(t, resp))
+
+  var body struct{ Event Event }
+  resp = doRequest("POST", url, sid, `{"event": {"title": "Citadel", "lat": "39.95", "lon": "-75.16",
+    "start_date": "Mon Jan 2 2017 15:04:05 EST-05:00"}}`)
+  assert.Equal(t, 200, resp.Code, resp.Body.String())
+  json.Unmarshal(resp.Body.Bytes(), &body)
+  assert.Equal(t, 2017, body.Event.StartDate.Year())
+
+  // updates are checked against the rest of the event
+  resp = doRequest("PUT", url + "/" + body.Event.Id, sid, `{"event": {"end_date": "Mon Jan 2 2017 09:00:00 EST-05:00"}}`)
+  assert.Equal(t, map[string]string{"end_date": FieldOutOfRange}, fieldErrors(t, resp))
+  resp = doRequest("PUT", url + "/" + body.Event.Id, sid, `{"event": {"title": " "}}`)
+  assert.Equal(t, map[string]string{"title": FieldRequired}, fieldErrors(t, resp))
+}
+
+func TestUserAndMessageValidation(t *testing.T) {
+  f := newPrivacyFixture(t, PrivacyPublic)
+  user, sid := newTestUser(t, "Gilly")
+
+  resp := doRequest("PUT", "/api/v1/users/" + user.Id, sid, `{"user": {"email": "gilly at craster's", "avatar": "ftp://keep/gilly.png"}}`)
+  assert.Equal(t, map[string]string{"email": FieldInvalid, "avatar": FieldInvalid}, fieldErrors(t, resp))
+  resp = doRequest("PUT", "/api/v1/users/" + user.Id, sid, `{"user": {"email": "gilly@horn-hill.com"}}`)
+  assert.Equal(t, 200, resp.Code)
+
+  url := "/api/v1/events/" + f.event.Id + "/messages"
+  resp = doRequest("POST", url, f.member, `{"message": {"content": "  "}}`)
+  assert.Equal(t, map[string]string{"content": FieldRequired}, fieldErrors(t, resp))
+  resp = doRequest("POST", url, f.member, `{"message": {"content": "` + strings.Repeat("a", maxContentLength + 1) + `"}}`)
+  assert.Equal(t, map[string]string{"content": FieldTooLong}, fieldErrors(t, resp))
+
+  message := postMessage(t, f, f.member, "Sam", "")
+  resp = doRequest("PUT", "/api/v1/messages/" + message.Id, f.member, `{"message": {"content": ""}}`)
+  assert.Equal(t, map[string]string{"content": FieldRequired}, fieldErrors(t, resp))
+}